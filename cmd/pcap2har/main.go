@@ -1,43 +1,204 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
+	"os"
 
-	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/pflag"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/tcpassembly"
 
 	"github.com/colinnewell/pcap-cli/cli"
+	"github.com/colinnewell/pcap2har-go/internal/grpc"
 	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/pcapng"
 	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/colinnewell/pcap2har-go/internal/tlsdecode"
 )
 
 func main() {
+	var assemblyDebug, displayVersion, sortEntries, rawFraming, fcgiRawParams bool
+	var serverPorts []int32
+	var keyLogFile, protoDescriptorSet, bodiesDir string
+	var maxBodySize, maxMemory int64
+
+	pflag.BoolVar(&displayVersion, "version", false, "Display program version")
+	pflag.BoolVar(&assemblyDebug, "assembly-debug", false, "Debug log from the tcp assembly")
+	pflag.BoolVar(&sortEntries, "sort", false, "Sort the entries by the time they started")
+	pflag.Int32SliceVar(&serverPorts, "server-ports", []int32{}, "Server ports")
+	pflag.StringVar(&keyLogFile, "keylog", "", "NSS key log file (SSLKEYLOGFILE) used to decrypt TLS")
+	pflag.BoolVar(&rawFraming, "raw-framing", false, "Record the chunks of bodies sent with chunked transfer encoding")
+	pflag.BoolVar(&fcgiRawParams, "fcgi-raw-params", false,
+		"List all the params sent with FastCGI requests, not just those that aren't part of the HTTP request")
+	pflag.Int64Var(&maxBodySize, "max-body-size", 0,
+		"Truncate bodies bigger than this many bytes (0 for no limit)")
+	pflag.Int64Var(&maxMemory, "max-memory", 0,
+		"Bytes of memory the bodies can use before they're spilled to temporary files (0 for no limit)")
+	pflag.StringVar(&bodiesDir, "extract-bodies", "",
+		"Directory to write the bodies to, named by their SHA-256, rather than including them in the entries")
+	pflag.StringVar(&protoDescriptorSet, "proto-descriptor-set", "",
+		"FileDescriptorSet (protoc --include_imports --descriptor_set_out) used to show gRPC messages as JSON")
+	pflag.Parse()
+
+	if displayVersion {
+		fmt.Printf("Version: %s\n", cli.Version)
+		return
+	}
+
+	if assemblyDebug {
+		// set the flag the pcap library reads to
+		// know it needs to output debug info.
+		if err := flag.Set("assembly_debug_log", "true"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	files := pflag.Args()
+	if len(files) == 0 {
+		log.Fatal("Must specify filename")
+	}
+
+	harOptions := har.WriterOptions{Sort: sortEntries}
+	if bodiesDir != "" {
+		if err := os.MkdirAll(bodiesDir, 0o755); err != nil {
+			log.Fatal(err)
+		}
+		harOptions.BodiesDir = bodiesDir
+	}
+	if protoDescriptorSet != "" {
+		decoder, err := grpc.LoadDescriptorSet(protoDescriptorSet)
+		if err != nil {
+			log.Fatal(err)
+		}
+		harOptions.GRPCDecoder = decoder
+	}
+
 	r := reader.New()
-	cli.Main("", r, output(r))
+	r.MaxBodySize = maxBodySize
+	r.MaxMemory = maxMemory
+	r.RawFraming = rawFraming
+	r.FCGIRawParams = fcgiRawParams
+	if keyLogFile != "" {
+		keys, err := tlsdecode.LoadKeyLog(keyLogFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n := keys.Skipped(); n > 0 {
+			log.Printf("%s: skipped %d lines that aren't TLS secrets", keyLogFile, n)
+		}
+		if keys.Len() == 0 {
+			log.Printf("%s: no TLS secrets found", keyLogFile)
+		}
+		r.KeyLog = keys
+	}
+	// the secrets need to be in place before we start reading any of the
+	// streams.
+	for _, filename := range files {
+		if err := addEmbeddedSecrets(r, filename); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// the entries are written out as each connection finishes.
+	written := make(chan interface{})
+	go output(os.Stdout, r.StreamConversations(), harOptions, written)
+
+	streamFactory := reader.NewStreamFactory(r)
+	streamPool := tcpassembly.NewStreamPool(streamFactory)
+	assembler := tcpassembly.NewAssembler(streamPool)
+
+	for _, filename := range files {
+		if handle, err := pcap.OpenOffline(filename); err != nil {
+			log.Fatal(err)
+		} else {
+			defer handle.Close()
+			packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+
+			for packet := range packetSource.Packets() {
+				if tcp, ok := packet.TransportLayer().(*layers.TCP); ok {
+					if allowPort(serverPorts, tcp) {
+						r.ObserveTCP(packet.NetworkLayer().NetworkFlow(),
+							tcp, packet.Metadata().Timestamp)
+						assembler.AssembleWithTimestamp(
+							packet.NetworkLayer().NetworkFlow(),
+							tcp, packet.Metadata().Timestamp)
+					}
+				} else if dns, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS); ok {
+					// lookups tell us the hostnames for the connections
+					// that follow.
+					r.ObserveDNS(dns, packet.Metadata().Timestamp)
+				}
+			}
+		}
+	}
+
+	assembler.FlushAll()
+	streamFactory.Finish()
+	<-written
 }
 
-func output(r *reader.HTTPConversationReaders) func(io.Writer, chan interface{}) {
-	return func(w io.Writer, completed chan interface{}) {
-		var har har.Har
-		har.Log.Version = "1.2"
-		har.Log.Creator.Name = "pcap2har"
-		har.Log.Creator.Version = cli.Version
+// addEmbeddedSecrets adds any TLS secrets stored in the Decryption Secrets
+// Blocks of a pcapng file to the key log.
+func addEmbeddedSecrets(r *reader.HTTPConversationReaders, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-		<-completed
+	keyLogs, err := pcapng.TLSKeyLogs(bufio.NewReader(f))
+	if errors.Is(err, pcapng.ErrNotPcapng) {
+		return nil
+	}
+	// a truncated capture may still have given us some secrets so hang on
+	// to what we've got.
+	for _, k := range keyLogs {
+		if r.KeyLog == nil {
+			r.KeyLog = tlsdecode.NewKeyLog()
+		}
+		if err := r.KeyLog.Parse(bytes.NewReader(k)); err != nil {
+			return fmt.Errorf("%s: embedded secrets: %w", filename, err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
 
-		c := r.GetConversations()
-		for _, v := range c {
-			har.AddEntry(v)
+func allowPort(serverPorts []int32, packet *layers.TCP) bool {
+	if len(serverPorts) == 0 {
+		return true
+	}
+	for _, port := range serverPorts {
+		if packet.SrcPort == layers.TCPPort(port) ||
+			packet.DstPort == layers.TCPPort(port) {
+			return true
 		}
-		har.FinaliseAndSort()
+	}
+	return false
+}
 
-		var json = jsoniter.ConfigCompatibleWithStandardLibrary
-		e := json.NewEncoder(w)
-		e.SetIndent("", "  ")
-		err := e.Encode(har)
-		if err != nil {
+// output writes the conversations out as a HAR document, closing written
+// once it's done.
+func output(w io.Writer, conversations <-chan reader.Conversation, options har.WriterOptions, written chan interface{}) {
+	defer close(written)
+	writer := har.NewWriter(w, har.Creator{Name: "pcap2har", Version: cli.Version}, options)
+	for c := range conversations {
+		if err := writer.Add(c); err != nil {
 			log.Println(err)
-			return
 		}
 	}
+	if err := writer.Close(); err != nil {
+		log.Println(err)
+	}
 }
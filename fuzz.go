@@ -4,10 +4,10 @@
 package fuzz
 
 import (
+	"io"
 	"log"
 	"os"
 
-	"github.com/colinnewell/pcap-cli/tcp"
 	"github.com/colinnewell/pcap2har-go/internal/reader"
 
 	"github.com/google/gopacket"
@@ -18,9 +18,8 @@ import (
 
 func Fuzz(data []byte) int {
 
-	streamFactory := &tcp.StreamFactory{
-		Reader: reader.New(),
-	}
+	r := reader.New()
+	streamFactory := reader.NewStreamFactory(r)
 	streamPool := tcpassembly.NewStreamPool(streamFactory)
 	assembler := tcpassembly.NewAssembler(streamPool)
 
@@ -57,6 +56,9 @@ func Fuzz(data []byte) int {
 
 	assembler.FlushAll()
 	//fmt.Printf("Found %d connections\n", connections)
-	streamFactory.Reader.GetConversations()
+	streamFactory.Output(io.Discard, func(_ io.Writer, completed chan interface{}) {
+		<-completed
+		r.GetConversations()
+	})
 	return 0
 }
@@ -0,0 +1,191 @@
+// Package contentencoding decodes HTTP bodies compressed with the codings
+// listed in their Content-Encoding header.
+//
+// gzip, deflate, br and zstd are supported out of the box, and others can be
+// added with Register.
+package contentencoding
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Decoder returns a reader for the content decoded from r.
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	mu       sync.RWMutex
+	decoders = map[string]Decoder{
+		"gzip":    gzipDecoder,
+		"x-gzip":  gzipDecoder,
+		"deflate": deflateDecoder,
+		"br":      brotliDecoder,
+		"zstd":    zstdDecoder,
+	}
+)
+
+// Register adds the decoder for a content coding, replacing any existing
+// decoder for it.
+func Register(coding string, d Decoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	decoders[strings.ToLower(coding)] = d
+}
+
+// Codings returns the content codings from the Content-Encoding header in
+// the order they were applied, leaving out identity.
+func Codings(header http.Header) []string {
+	var codings []string
+	for _, value := range header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	return codings
+}
+
+// Decode undoes the content codings applied to body, last applied first.  If
+// any of them can't be decoded the error explains why, and the body should be
+// left as it was.
+func Decode(body []byte, codings []string) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(body), codings)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// NewReader returns a reader for the content read from r with the content
+// codings undone, last applied first.  It's for bodies that are too big to
+// decode in one go.
+func NewReader(r io.Reader, codings []string) (io.ReadCloser, error) {
+	s := &stackedReader{r: r}
+	for i := len(codings) - 1; i >= 0; i-- {
+		mu.RLock()
+		d, ok := decoders[codings[i]]
+		mu.RUnlock()
+		if !ok {
+			s.Close()
+			return nil, fmt.Errorf("unsupported content encoding %q", codings[i])
+		}
+		dr, err := d(s.r)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("decoding %s: %w", codings[i], err)
+		}
+		s.closers = append(s.closers, dr)
+		s.r = &codingReader{coding: codings[i], r: dr}
+	}
+	return s, nil
+}
+
+// stackedReader reads through all the decoders, closing them all once it's
+// done.
+type stackedReader struct {
+	r       io.Reader
+	closers []io.Closer
+}
+
+func (s *stackedReader) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *stackedReader) Close() error {
+	var err error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if cerr := s.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	s.closers = nil
+	return err
+}
+
+// codingReader says which coding couldn't be decoded when reading fails.
+type codingReader struct {
+	coding string
+	r      io.Reader
+}
+
+func (c *codingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		var coded *codingError
+		if !errors.As(err, &coded) {
+			err = &codingError{coding: c.coding, err: err}
+		}
+	}
+	return n, err
+}
+
+// codingError is the first error decoding the body, along with the coding
+// that produced it.
+type codingError struct {
+	coding string
+	err    error
+}
+
+func (e *codingError) Error() string {
+	return fmt.Sprintf("decoding %s: %s", e.coding, e.err)
+}
+
+func (e *codingError) Unwrap() error {
+	return e.err
+}
+
+func gzipDecoder(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// deflateDecoder handles deflate in a zlib wrapper, as the spec says it
+// should be sent, along with the raw deflate some servers send instead.
+func deflateDecoder(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+	header, err := buf.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if isZlibHeader(header) {
+		return zlib.NewReader(buf)
+	}
+	return flate.NewReader(buf), nil
+}
+
+// isZlibHeader checks for the deflate method and header checksum that start
+// a zlib stream.  Preset dictionaries aren't supported so they're not
+// counted either.
+func isZlibHeader(header []byte) bool {
+	if len(header) < 2 {
+		return false
+	}
+	cmf, flg := header[0], header[1]
+	return cmf&0x0f == 8 && (uint16(cmf)<<8|uint16(flg))%31 == 0 && flg&0x20 == 0
+}
+
+func brotliDecoder(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
+
+func zstdDecoder(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
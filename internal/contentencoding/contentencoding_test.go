@@ -0,0 +1,114 @@
+package contentencoding_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/colinnewell/pcap2har-go/internal/contentencoding"
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t *testing.T, data []byte, newWriter func(io.Writer) io.WriteCloser) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+func zlibWriter(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+
+func flateWriter(w io.Writer) io.WriteCloser {
+	f, _ := flate.NewWriter(w, flate.DefaultCompression)
+	return f
+}
+
+func brotliWriter(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }
+
+func zstdWriter(w io.Writer) io.WriteCloser {
+	z, _ := zstd.NewWriter(w)
+	return z
+}
+
+func TestDecode(t *testing.T) {
+	content := []byte(strings.Repeat("some text to compress ", 20))
+	tests := []struct {
+		name     string
+		encoding []string
+		body     []byte
+	}{
+		{"gzip", []string{"gzip"}, compress(t, content, gzipWriter)},
+		{"deflate", []string{"deflate"}, compress(t, content, zlibWriter)},
+		{"raw deflate", []string{"deflate"}, compress(t, content, flateWriter)},
+		{"brotli", []string{"br"}, compress(t, content, brotliWriter)},
+		{"zstd", []string{"zstd"}, compress(t, content, zstdWriter)},
+		{"identity", []string{"identity"}, content},
+		{
+			"stacked",
+			[]string{"gzip, br"},
+			compress(t, compress(t, content, gzipWriter), brotliWriter),
+		},
+		{
+			"separate headers",
+			[]string{"zstd", "GZIP"},
+			compress(t, compress(t, content, zstdWriter), gzipWriter),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{"Content-Encoding": test.encoding}
+			decoded, err := contentencoding.Decode(test.body, contentencoding.Codings(header))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(decoded), string(content)); diff != "" {
+				t.Errorf("Decoded body doesn't match (-got +expected):\n%s\n", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := contentencoding.Decode([]byte("plain"), []string{"gzip"}); err == nil {
+		t.Error("Expected an error decoding a body that isn't gzipped")
+	}
+	_, err := contentencoding.Decode([]byte("plain"), []string{"compress"})
+	if err == nil || err.Error() != `unsupported content encoding "compress"` {
+		t.Errorf("Expected an unsupported encoding error, got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	contentencoding.Register("X-Reverse", func(r io.Reader) (io.ReadCloser, error) {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(body)-1; i < j; i, j = i+1, j-1 {
+			body[i], body[j] = body[j], body[i]
+		}
+		return io.NopCloser(bytes.NewReader(body)), nil
+	})
+	decoded, err := contentencoding.Decode([]byte("olleh"), []string{"x-reverse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "hello" {
+		t.Errorf("Expected hello, got %q", decoded)
+	}
+}
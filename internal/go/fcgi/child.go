@@ -29,16 +29,32 @@ import (
 type request struct {
 	pw        *io.PipeWriter
 	params    map[string]string
+	names     []string
 	buf       [1024]byte
 	rawParams []byte
 	reqId     uint16
 	keepConn  bool
+	// served is done once the request or response has been passed on to
+	// the DataGatherer, if serving has started.
+	serving bool
+	served  sync.WaitGroup
+	// ended is set once the whole request has been read.  It's kept in
+	// case the web server aborts it while the application deals with it.
+	ended bool
 }
 
 // envVarsContextKey uniquely identifies a mapping of CGI
 // environment variables to their values in a request context.
 type envVarsContextKey struct{}
 
+// paramsContextKey identifies all the params sent for the request in its
+// context.
+type paramsContextKey struct{}
+
+// paramNamesContextKey identifies the names of the params in the order they
+// were sent.
+type paramNamesContextKey struct{}
+
 var httpStatus = regexp.MustCompile(`(?m)^Status:\s*(.*)\s*$`)
 
 func newRequest(reqId uint16, flags uint8) *request {
@@ -73,15 +89,31 @@ func (r *request) parseParams() {
 		text = text[keyLen:]
 		val := readString(text, valLen)
 		text = text[valLen:]
+		if _, ok := r.params[key]; !ok {
+			r.names = append(r.names, key)
+		}
 		r.params[key] = val
 	}
 }
 
+// DataGatherer is given what's read from the stream, along with the ID of
+// the request it's for.  Requests can be multiplexed on a connection, and
+// the IDs reused once a request has ended.
 type DataGatherer interface {
-	ErrorInfo(string)
-	RequestInfo(*http.Request)
-	ResponseInfo(*http.Response, []byte)
-	ReturnValue(int)
+	// Received is called as each record is read, before it's dealt with,
+	// so that what was read can be put down to the request it's for.
+	Received(id uint16)
+	ErrorInfo(id uint16, message string)
+	RequestInfo(id uint16, req *http.Request)
+	// ResponseInfo is given the header block, which is the status line we
+	// make up followed by the headers as the application wrote them.
+	ResponseInfo(id uint16, res *http.Response, header, body []byte)
+	// EndRequest is called after the response, if there was one, with the
+	// application's exit status and how the request was dealt with.
+	EndRequest(id uint16, appStatus int, protocolStatus ProtocolStatus)
+	// AbortRequest is called after the request when the web server aborts
+	// it.
+	AbortRequest(id uint16)
 }
 
 type Child struct {
@@ -109,6 +141,7 @@ func (c *Child) ReadRequest(rdr io.Reader) error {
 		if err := rec.read(rdr); err != nil {
 			return err
 		}
+		c.dg.Received(rec.h.Id)
 		if err := c.handleRecord(&rec); err != nil {
 			return err
 		}
@@ -128,8 +161,6 @@ func (c *Child) cleanUp() {
 	}
 }
 
-var emptyBody = io.NopCloser(strings.NewReader(""))
-
 // ErrConnClosed is returned by Read when a handler attempts to read the body of
 // a request after the connection to the web server has been closed.
 var ErrConnClosed = errors.New("fcgi: connection to web server closed")
@@ -141,6 +172,7 @@ func (c *Child) handleRecord(rec *record) error {
 		rec.h.Type != typeBeginRequest &&
 		rec.h.Type != typeStdout &&
 		rec.h.Type != typeStderr &&
+		rec.h.Type != typeEndRequest &&
 		rec.h.Type != typeGetValues {
 		// The spec says to ignore unknown request IDs.
 		return nil
@@ -148,7 +180,7 @@ func (c *Child) handleRecord(rec *record) error {
 
 	switch rec.h.Type {
 	case typeBeginRequest:
-		if req != nil {
+		if req != nil && !req.ended {
 			// The server is trying to begin a request with the same ID
 			// as an in-progress request. This is an error.
 			return errors.New("fcgi: received ID that is already in-flight")
@@ -176,7 +208,10 @@ func (c *Child) handleRecord(rec *record) error {
 		req.parseParams()
 		return nil
 	case typeStderr:
-		c.dg.ErrorInfo(string(rec.content()))
+		// an empty record ends the stream.
+		if content := rec.content(); len(content) > 0 {
+			c.dg.ErrorInfo(rec.h.Id, string(content))
+		}
 		return nil
 	case typeStdout:
 		if req, ok = c.requests[rec.h.Id]; !ok {
@@ -191,10 +226,12 @@ func (c *Child) handleRecord(rec *record) error {
 				// as long as both sides are behaving.
 				body, req.pw = io.Pipe()
 			} else {
-				body = emptyBody
+				body = http.NoBody
 			}
 			c.wg.Add(1)
-			go c.serveResponse(body)
+			req.serving = true
+			req.served.Add(1)
+			go c.serveResponse(req, body)
 		}
 		if len(content) > 0 {
 			if !ok {
@@ -214,11 +251,14 @@ func (c *Child) handleRecord(rec *record) error {
 			if err != nil {
 				return err
 			}
-		} else if req.pw != nil {
-			err := req.pw.Close()
-			if err != nil {
-				return err
+		} else {
+			if req.pw != nil {
+				if err := req.pw.Close(); err != nil {
+					return err
+				}
 			}
+			// pass them on in the order they finish.
+			req.served.Wait()
 		}
 		return nil
 	case typeStdin:
@@ -230,9 +270,11 @@ func (c *Child) handleRecord(rec *record) error {
 				// as long as both sides are behaving.
 				body, req.pw = io.Pipe()
 			} else {
-				body = emptyBody
+				body = http.NoBody
 			}
 			c.wg.Add(1)
+			req.serving = true
+			req.served.Add(1)
 			go c.serveRequest(req, body)
 		}
 		if len(content) > 0 {
@@ -242,11 +284,15 @@ func (c *Child) handleRecord(rec *record) error {
 			if err != nil {
 				return err
 			}
-		} else if req.pw != nil {
-			err := req.pw.Close()
-			if err != nil {
-				return err
+		} else {
+			if req.pw != nil {
+				if err := req.pw.Close(); err != nil {
+					return err
+				}
 			}
+			// pass them on in the order they finish.
+			req.served.Wait()
+			req.ended = true
 		}
 		return nil
 	case typeGetValues:
@@ -255,18 +301,35 @@ func (c *Child) handleRecord(rec *record) error {
 		// but not the fact that it was requested.
 		return nil
 	case typeEndRequest:
-		if req.pw != nil {
-			req.pw.Close()
+		var er endRequest
+		if err := er.read(rec.content()); err != nil {
+			return err
 		}
-		delete(c.requests, rec.h.Id)
+		if req != nil {
+			// the response needs to be passed on first so the status goes
+			// with it.
+			if req.pw != nil {
+				req.pw.Close()
+			}
+			req.served.Wait()
+			delete(c.requests, rec.h.Id)
+		}
+		c.dg.EndRequest(rec.h.Id, int(er.appStatus), er.protocolStatus)
 		return nil
 	case typeData:
 		// If the filter role is implemented, read the data stream here.
 		// FIXME:
 		return nil
 	case typeAbortRequest:
-		// perhaps add that to the HAR?
+		// the web server can give up before sending the whole body.
+		if req.pw != nil {
+			req.pw.Close()
+		}
+		req.served.Wait()
 		delete(c.requests, rec.h.Id)
+		if req.serving {
+			c.dg.AbortRequest(rec.h.Id)
+		}
 		return nil
 	default:
 		// FIXME: perhaps log for now?
@@ -274,22 +337,50 @@ func (c *Child) handleRecord(rec *record) error {
 	}
 }
 
-func (c *Child) serveResponse(body io.Reader) {
+func (c *Child) serveResponse(req *request, body io.Reader) {
 	// FIXME: it would be nice to pass more meta data through the request too
 	defer c.wg.Done()
-	buf := bufio.NewReader(body)
+	defer req.served.Done()
+	rec := &headerRecorder{r: body}
+	buf := bufio.NewReader(rec)
 	res, err := http.ReadResponse(buf, nil)
 	if err != nil {
 		return
 	}
+	header := rec.header(buf)
 	defer res.Body.Close()
 	// FIXME: consider a savepoint reader to have another crack at the body?
 	respBody, _ := io.ReadAll(res.Body)
-	c.dg.ResponseInfo(res, respBody)
+	c.dg.ResponseInfo(req.reqId, res, header, respBody)
+}
+
+// headerRecorder keeps what's read until the header block has been taken.
+type headerRecorder struct {
+	r       io.Reader
+	raw     []byte
+	stopped bool
+}
+
+func (h *headerRecorder) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if !h.stopped {
+		h.raw = append(h.raw, p[:n]...)
+	}
+	return n, err
+}
+
+// header returns the bytes buf has consumed, which after parsing the
+// response is its header block, and stops recording.
+func (h *headerRecorder) header(buf *bufio.Reader) []byte {
+	header := h.raw[:len(h.raw)-buf.Buffered()]
+	h.raw = nil
+	h.stopped = true
+	return header
 }
 
 func (c *Child) serveRequest(req *request, body io.ReadCloser) {
 	defer c.wg.Done()
+	defer req.served.Done()
 	httpReq, err := cgi.RequestFromMap(req.params)
 	if err != nil {
 		return
@@ -297,8 +388,10 @@ func (c *Child) serveRequest(req *request, body io.ReadCloser) {
 	httpReq.Body = body
 	withoutUsedEnvVars := filterOutUsedEnvVars(req.params)
 	envVarCtx := context.WithValue(httpReq.Context(), envVarsContextKey{}, withoutUsedEnvVars)
+	envVarCtx = context.WithValue(envVarCtx, paramsContextKey{}, req.params)
+	envVarCtx = context.WithValue(envVarCtx, paramNamesContextKey{}, req.names)
 	httpReq = httpReq.WithContext(envVarCtx)
-	c.dg.RequestInfo(httpReq)
+	c.dg.RequestInfo(req.reqId, httpReq)
 }
 
 // filterOutUsedEnvVars returns a new map of env vars without the
@@ -324,6 +417,20 @@ func ProcessEnv(r *http.Request) map[string]string {
 	return env
 }
 
+// Params returns all the FastCGI params sent for the request r, including
+// those that went into making r.
+func Params(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(paramsContextKey{}).(map[string]string)
+	return params
+}
+
+// ParamNames returns the names of all the FastCGI params sent for the
+// request r, in the order they were sent.
+func ParamNames(r *http.Request) []string {
+	names, _ := r.Context().Value(paramNamesContextKey{}).([]string)
+	return names
+}
+
 // addFastCGIEnvToContext reports whether to include the FastCGI environment variable s
 // in the http.Request.Context, accessible via ProcessEnv.
 func addFastCGIEnvToContext(s string) bool {
@@ -18,6 +18,7 @@ package fcgi
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 )
 
@@ -73,6 +74,45 @@ func (br *beginRequest) read(content []byte) error {
 	return nil
 }
 
+// ProtocolStatus is how the application says it dealt with a request at the
+// end of it.
+type ProtocolStatus uint8
+
+const (
+	RequestComplete ProtocolStatus = iota
+	CantMultiplexConn
+	Overloaded
+	UnknownRole
+)
+
+func (s ProtocolStatus) String() string {
+	switch s {
+	case RequestComplete:
+		return "REQUEST_COMPLETE"
+	case CantMultiplexConn:
+		return "CANT_MPX_CONN"
+	case Overloaded:
+		return "OVERLOADED"
+	case UnknownRole:
+		return "UNKNOWN_ROLE"
+	}
+	return fmt.Sprintf("UNKNOWN_%d", uint8(s))
+}
+
+type endRequest struct {
+	appStatus      uint32
+	protocolStatus ProtocolStatus
+}
+
+func (er *endRequest) read(content []byte) error {
+	if len(content) != 8 {
+		return errors.New("fcgi: invalid end request record")
+	}
+	er.appStatus = binary.BigEndian.Uint32(content)
+	er.protocolStatus = ProtocolStatus(content[4])
+	return nil
+}
+
 type record struct {
 	h   header
 	buf [maxWrite + maxPad]byte
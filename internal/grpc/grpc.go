@@ -0,0 +1,173 @@
+// Package grpc splits gRPC bodies into their messages and, given the
+// protobuf descriptors, renders those messages as JSON.
+//
+// See https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md for the
+// framing.
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const prefixLen = 5
+
+// ErrTruncated is returned when the body ends part way through a message.
+var ErrTruncated = errors.New("grpc: truncated message")
+
+// Message is a single length prefixed message from a gRPC body.
+type Message struct {
+	Compressed bool
+	Data       []byte
+}
+
+// IsGRPC checks whether the content type is one used for gRPC.
+func IsGRPC(contentType string) bool {
+	return contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// Messages splits a body into its messages.  If the body is truncated the
+// messages before that point are returned along with ErrTruncated.
+func Messages(body []byte) ([]Message, error) {
+	return ReadMessages(bytes.NewReader(body))
+}
+
+// ReadMessages reads the messages from a body, like Messages, without
+// needing the whole body in memory first.
+func ReadMessages(r io.Reader) ([]Message, error) {
+	var messages []Message
+	prefix := make([]byte, prefixLen)
+	for {
+		if _, err := io.ReadFull(r, prefix); err != nil {
+			switch err {
+			case io.EOF:
+				return messages, nil
+			case io.ErrUnexpectedEOF:
+				return messages, ErrTruncated
+			}
+			return messages, err
+		}
+		length := int64(binary.BigEndian.Uint32(prefix[1:]))
+		// the buffer grows as the message is read rather than trusting the
+		// length, as the body may end before it.
+		data := bytes.NewBuffer(make([]byte, 0, min(length, 64*1024)))
+		if _, err := io.CopyN(data, r, length); err != nil {
+			if err == io.EOF {
+				return messages, ErrTruncated
+			}
+			return messages, err
+		}
+		messages = append(messages, Message{
+			Compressed: prefix[0]&1 == 1,
+			Data:       data.Bytes(),
+		})
+	}
+}
+
+// Decoder renders messages as JSON using the descriptors for the services.
+type Decoder struct {
+	files *protoregistry.Files
+	// types allows Any fields to be rendered with the types in the
+	// descriptor set.
+	types *dynamicpb.Types
+}
+
+// LoadDescriptorSet reads a FileDescriptorSet, like the one produced by
+// `protoc --include_imports --descriptor_set_out`.
+func LoadDescriptorSet(filename string) (*Decoder, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return NewDecoder(&set)
+}
+
+// NewDecoder creates a Decoder from a FileDescriptorSet.
+func NewDecoder(set *descriptorpb.FileDescriptorSet) (*Decoder, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{files: files, types: dynamicpb.NewTypes(files)}, nil
+}
+
+// JSON renders the message as JSON.  The path of the request,
+// /package.Service/Method, determines the message type, and encoding is the
+// grpc-encoding used for compressed messages.
+func (d *Decoder) JSON(path string, request bool, encoding string, m Message) (string, error) {
+	method, err := d.method(path)
+	if err != nil {
+		return "", err
+	}
+	desc := method.Output()
+	if request {
+		desc = method.Input()
+	}
+
+	data := m.Data
+	if m.Compressed {
+		if data, err = decompress(encoding, data); err != nil {
+			return "", err
+		}
+	}
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return "", err
+	}
+	b, err := protojson.MarshalOptions{Resolver: d.types}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *Decoder) method(path string) (protoreflect.MethodDescriptor, error) {
+	service, name, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("grpc: invalid method path %q", path)
+	}
+	desc, err := d.files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("grpc: %s: %w", service, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("grpc: %s is not a service", service)
+	}
+	method := sd.Methods().ByName(protoreflect.Name(name))
+	if method == nil {
+		return nil, fmt.Errorf("grpc: %s has no method %s", service, name)
+	}
+	return method, nil
+}
+
+func decompress(encoding string, data []byte) ([]byte, error) {
+	if encoding != "gzip" {
+		return nil, fmt.Errorf("grpc: unsupported encoding %q", encoding)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
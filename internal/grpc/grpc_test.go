@@ -0,0 +1,133 @@
+package grpc_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/colinnewell/pcap2har-go/internal/grpc"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func frame(compressed bool, data []byte) []byte {
+	var flag byte
+	if compressed {
+		flag = 1
+	}
+	b := append([]byte{flag}, binary.BigEndian.AppendUint32(nil, uint32(len(data)))...)
+	return append(b, data...)
+}
+
+// descriptors for
+//
+//	package test;
+//	message Greeting { string name = 1; int32 count = 2; }
+//	service Greeter { rpc Greet(Greeting) returns (Greeting); }
+func descriptors() *descriptorpb.FileDescriptorSet {
+	return &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("test.proto"),
+			Package: proto.String("test"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Greeting"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:     proto.String("name"),
+						JsonName: proto.String("name"),
+						Number:   proto.Int32(1),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					},
+					{
+						Name:     proto.String("count"),
+						JsonName: proto.String("count"),
+						Number:   proto.Int32(2),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+					},
+				},
+			}},
+			Service: []*descriptorpb.ServiceDescriptorProto{{
+				Name: proto.String("Greeter"),
+				Method: []*descriptorpb.MethodDescriptorProto{{
+					Name:       proto.String("Greet"),
+					InputType:  proto.String(".test.Greeting"),
+					OutputType: proto.String(".test.Greeting"),
+				}},
+			}},
+		}},
+	}
+}
+
+func TestMessages(t *testing.T) {
+	body := append(frame(false, []byte{1, 2}), frame(true, []byte{3})...)
+	body = append(body, frame(false, nil)...)
+
+	messages, err := grpc.Messages(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []grpc.Message{
+		{Data: []byte{1, 2}},
+		{Compressed: true, Data: []byte{3}},
+		{Data: []byte{}},
+	}
+	if diff := cmp.Diff(messages, expected); diff != "" {
+		t.Errorf("Messages don't match (-got +expected):\n%s\n", diff)
+	}
+
+	messages, err = grpc.Messages(body[:len(body)-3])
+	if !errors.Is(err, grpc.ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	if len(messages) != 2 {
+		t.Errorf("Expected the 2 complete messages, got %d", len(messages))
+	}
+}
+
+func TestDecoderJSON(t *testing.T) {
+	d, err := grpc.NewDecoder(descriptors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// name: "bob", count: 3
+	data := []byte{0x0a, 0x03, 'b', 'o', 'b', 0x10, 0x03}
+
+	text, err := d.JSON("/test.Greeter/Greet", true, "", grpc.Message{Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(compact(text), `{"name":"bob","count":3}`); diff != "" {
+		t.Errorf("JSON doesn't match (-got +expected):\n%s\n", diff)
+	}
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	text, err = d.JSON("/test.Greeter/Greet", false, "gzip",
+		grpc.Message{Compressed: true, Data: compressed.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(compact(text), `{"name":"bob","count":3}`); diff != "" {
+		t.Errorf("JSON doesn't match (-got +expected):\n%s\n", diff)
+	}
+
+	if _, err := d.JSON("/test.Greeter/Missing", true, "", grpc.Message{Data: data}); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+}
+
+// compact removes the whitespace protojson randomly adds to stop people
+// relying on its output.
+func compact(text string) string {
+	return string(bytes.Join(bytes.Fields([]byte(text)), nil))
+}
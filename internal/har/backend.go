@@ -0,0 +1,50 @@
+package har
+
+import (
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// BackendCall is a FastCGI request the web server made while handling the
+// entry's request.  Its time is how long the application took, the rest of
+// the entry's wait is the web server.
+type BackendCall struct {
+	StartedDateTime    time.Time    `json:"startedDateTime"`
+	Time               float64      `json:"time"`
+	Method             string       `json:"method"`
+	URL                string       `json:"url"`
+	ServerIPAddress    string       `json:"serverIPAddress"`
+	Status             int          `json:"status"`
+	Timings            EntryTimings `json:"timings"`
+	FCGIErrors         []FCGIError  `json:"_fcgiErrors,omitempty"`
+	FCGIAppStatus      *int         `json:"_fcgiAppStatus,omitempty"`
+	FCGIProtocolStatus string       `json:"_fcgiProtocolStatus,omitempty"`
+	FCGIAborted        bool         `json:"_fcgiAborted,omitempty"`
+}
+
+func extractBackendCalls(calls []reader.BackendCall) []BackendCall {
+	var harCalls []BackendCall
+	for _, c := range calls {
+		if len(c.RequestSeen) == 0 {
+			continue
+		}
+		start, timings := entryTimings(reader.Conversation{
+			RequestSeen: c.RequestSeen, ResponseSeen: c.ResponseSeen,
+		})
+		harCalls = append(harCalls, BackendCall{
+			StartedDateTime:    start,
+			Time:               timings.total(),
+			Method:             c.Method,
+			URL:                c.RequestURI,
+			ServerIPAddress:    c.Address.IP.Dst().String(),
+			Status:             c.Status,
+			Timings:            timings,
+			FCGIErrors:         extractFCGIErrors(c.Errors, nil),
+			FCGIAppStatus:      c.AppStatus,
+			FCGIProtocolStatus: c.ProtocolStatus,
+			FCGIAborted:        c.Aborted,
+		})
+	}
+	return harCalls
+}
@@ -0,0 +1,99 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarBackendCalls(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	u, err := url.Parse("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exitStatus := 255
+	errorSeen := at(6)
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{
+			IP:   gopacket.NewFlow(1, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}),
+			Port: gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x00, 0x50}),
+		},
+		Request: &http.Request{
+			Method: "GET", URL: u, Host: "example.com", Proto: "HTTP/1.1",
+			ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{},
+		},
+		RequestSeen: []time.Time{at(0)},
+		BackendCalls: []reader.BackendCall{{
+			Address: reader.ConversationAddress{
+				IP:   gopacket.NewFlow(1, []byte{10, 0, 0, 2}, []byte{10, 0, 0, 3}),
+				Port: gopacket.NewFlow(4, []byte{0xc3, 0x51}, []byte{0x23, 0x28}),
+			},
+			Method:       "GET",
+			RequestURI:   "/index.php",
+			RequestSeen:  []time.Time{at(1), at(2)},
+			ResponseSeen: []time.Time{at(5), at(8)},
+			Status:       500,
+			Errors:       []reader.FCGIError{{Message: "PHP Fatal error: oops", Seen: at(6)}},
+			AppStatus:    &exitStatus,
+		}},
+	})
+
+	expected := []har.BackendCall{{
+		StartedDateTime: at(1),
+		Time:            7,
+		Method:          "GET",
+		URL:             "/index.php",
+		ServerIPAddress: "10.0.0.3",
+		Status:          500,
+		Timings: har.EntryTimings{
+			Blocked: -1, BlockedQueueing: -1, Connect: -1, DNS: -1,
+			Send: 1, Wait: 3, Receive: 3, SSL: -1,
+		},
+		FCGIErrors:    []har.FCGIError{{Message: "PHP Fatal error: oops", Time: &errorSeen}},
+		FCGIAppStatus: &exitStatus,
+	}}
+	if diff := cmp.Diff(h.Log.Entries[0].BackendCalls, expected); diff != "" {
+		t.Errorf("Backend calls don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestHarFCGIEnd(t *testing.T) {
+	u, err := url.Parse("/index.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exitStatus := 0
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{
+			IP:   gopacket.NewFlow(1, []byte{10, 0, 0, 2}, []byte{10, 0, 0, 3}),
+			Port: gopacket.NewFlow(4, []byte{0xc3, 0x51}, []byte{0x23, 0x28}),
+		},
+		Request: &http.Request{
+			Method: "GET", URL: u, Host: "example.com", Proto: "HTTP/1.1",
+			ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{},
+		},
+		RequestSeen:        []time.Time{time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)},
+		FastCGI:            true,
+		FCGIAppStatus:      &exitStatus,
+		FCGIProtocolStatus: "OVERLOADED",
+		FCGIAborted:        true,
+	})
+
+	res := h.Log.Entries[0].Response
+	if res.FCGIAppStatus == nil || *res.FCGIAppStatus != 0 ||
+		res.FCGIProtocolStatus != "OVERLOADED" || !res.FCGIAborted {
+		t.Errorf("Unexpected FastCGI ending %v %q %v", res.FCGIAppStatus, res.FCGIProtocolStatus, res.FCGIAborted)
+	}
+}
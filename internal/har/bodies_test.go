@@ -0,0 +1,134 @@
+package har_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarSpilledBody(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "body")
+	if err := os.WriteFile(name, []byte("from disk"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse("/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{
+			IP:   gopacket.NewFlow(1, []byte{127, 0, 0, 1}, []byte{127, 0, 0, 1}),
+			Port: gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90}),
+		},
+		Request: &http.Request{
+			Method: "GET", URL: u, Host: "localhost:8080", Proto: "HTTP/1.1",
+			ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{},
+		},
+		Response: &http.Response{
+			Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header: http.Header{"Content-Type": {"text/plain"}},
+		},
+		RequestSeen:       []time.Time{time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)},
+		ResponseBodyFile:  &reader.BodyFile{Name: name, Size: 9},
+		ResponseTruncated: true,
+	})
+
+	e := h.Log.Entries[0]
+	if e.Response.Content.Text != "from disk" {
+		t.Errorf("Expected the body from the file, got %q", e.Response.Content.Text)
+	}
+	if !e.Truncated {
+		t.Error("Expected the entry to be marked as truncated")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to have been removed, got %v", err)
+	}
+}
+
+func TestHarSpilledBodyStreamed(t *testing.T) {
+	dir := t.TempDir()
+	spill := func(content string) *reader.BodyFile {
+		f, err := os.CreateTemp(dir, "body-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		return &reader.BodyFile{Name: f.Name(), Size: int64(len(content))}
+	}
+	conversation := func(requestType, responseType string, request, response *reader.BodyFile) reader.Conversation {
+		u, err := url.Parse("/upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reader.Conversation{
+			Address: reader.ConversationAddress{
+				IP:   gopacket.NewFlow(1, []byte{127, 0, 0, 1}, []byte{127, 0, 0, 1}),
+				Port: gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90}),
+			},
+			Request: &http.Request{
+				Method: "POST", URL: u, Host: "localhost:8080", Proto: "HTTP/1.1",
+				ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{"Content-Type": {requestType}},
+			},
+			Response: &http.Response{
+				Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+				Header: http.Header{"Content-Type": {responseType}},
+			},
+			RequestSeen:      []time.Time{time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)},
+			RequestBodyFile:  request,
+			ResponseBodyFile: response,
+		}
+	}
+
+	var h har.Har
+	h.AddEntry(conversation("application/x-www-form-urlencoded", "image/png",
+		spill("a=1&b=two+words"), spill("\x89PNG\r\n\x1a\n\x00")))
+	e := h.Log.Entries[0]
+	params := []har.PostData{{Name: "a", Value: "1"}, {Name: "b", Value: "two words"}}
+	if diff := cmp.Diff(e.Request.Content.Params, params); diff != "" {
+		t.Errorf("Params don't match (-got +expected):\n%s\n", diff)
+	}
+	if e.Response.Content.Text != "iVBORw0KGgoA" || e.Response.Content.Encoding != "base64" {
+		t.Errorf("Expected the body base64 encoded, got %q %q",
+			e.Response.Content.Text, e.Response.Content.Encoding)
+	}
+	if e.Response.Content.Size != 9 {
+		t.Errorf("Expected the size of the file, got %d", e.Response.Content.Size)
+	}
+
+	bodies := t.TempDir()
+	h = har.Har{Options: har.Options{BodiesDir: bodies}}
+	h.AddEntry(conversation("text/plain", "text/plain", spill(""), spill("from disk")))
+	content := h.Log.Entries[0].Response.Content
+	sum := sha256.Sum256([]byte("from disk"))
+	if content.SHA256 != hex.EncodeToString(sum[:]) || content.Text != "" {
+		t.Errorf("Expected the body to be extracted, got %q %q", content.SHA256, content.Text)
+	}
+	written, err := os.ReadFile(content.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != "from disk" {
+		t.Errorf("Expected the body to be copied from the file, got %q", written)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected the temporary files to have been removed, got %d", len(files))
+	}
+}
@@ -0,0 +1,40 @@
+package har
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// body is a request or response body, either held in memory or in the
+// temporary file it was spilled to.  A spilled body is read from the file
+// each time it's needed rather than being loaded back into memory.
+type body struct {
+	data []byte
+	file *reader.BodyFile
+}
+
+func requestBody(v reader.Conversation) body {
+	return body{data: v.RequestBody, file: v.RequestBodyFile}
+}
+
+func responseBody(v reader.Conversation) body {
+	return body{data: v.ResponseBody, file: v.ResponseBodyFile}
+}
+
+// size is the length of the body.
+func (b body) size() int64 {
+	if b.file != nil {
+		return b.file.Size
+	}
+	return int64(len(b.data))
+}
+
+// open returns a reader for the body.
+func (b body) open() (io.ReadCloser, error) {
+	if b.file != nil {
+		return b.file.Open()
+	}
+	return io.NopCloser(bytes.NewReader(b.data)), nil
+}
@@ -0,0 +1,37 @@
+package har
+
+import (
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// Event is an event from a text/event-stream response.
+type Event struct {
+	Time  time.Time `json:"time"`
+	ID    string    `json:"id,omitempty"`
+	Event string    `json:"event"`
+	Data  string    `json:"data"`
+	// Retry is the reconnection time in milliseconds, when it was set.
+	Retry *int `json:"retry,omitempty"`
+}
+
+func extractEvents(events []reader.ServerSentEvent) []Event {
+	if len(events) == 0 {
+		return nil
+	}
+	harEvents := make([]Event, len(events))
+	for i, e := range events {
+		harEvents[i] = Event{
+			Time:  e.Time,
+			ID:    e.ID,
+			Event: e.Event,
+			Data:  e.Data,
+		}
+		if e.Retry >= 0 {
+			retry := e.Retry
+			harEvents[i].Retry = &retry
+		}
+	}
+	return harEvents
+}
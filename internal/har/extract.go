@@ -0,0 +1,137 @@
+package har
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// extensions are the file extensions for common mime types, where the
+// system's mime tables would give an obscure one, or might not know it.
+var extensions = map[string]string{
+	"application/grpc":         ".grpc",
+	"application/javascript":   ".js",
+	"application/json":         ".json",
+	"application/octet-stream": ".bin",
+	"application/pdf":          ".pdf",
+	"application/wasm":         ".wasm",
+	"application/xml":          ".xml",
+	"font/woff":                ".woff",
+	"font/woff2":               ".woff2",
+	"image/gif":                ".gif",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/svg+xml":            ".svg",
+	"image/webp":               ".webp",
+	"text/css":                 ".css",
+	"text/event-stream":        ".txt",
+	"text/html":                ".html",
+	"text/javascript":          ".js",
+	"text/plain":               ".txt",
+	"text/xml":                 ".xml",
+}
+
+// extension guesses the file extension for the mime type, falling back to
+// .bin when it's not one we know.
+func extension(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := extensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// extractBody writes the body to a file in BodiesDir named by its SHA-256,
+// and has the content refer to the file rather than include the text.  A
+// body that's been seen before is already there so isn't written again.
+// It returns false when the body wasn't extracted, including when the file
+// couldn't be written, so the text needs to be included instead.
+func (h *Har) extractBody(content *ContentInfo, b body) bool {
+	if h.BodiesDir == "" || b.size() == 0 {
+		return false
+	}
+	hash, err := bodyHash(b)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	name := filepath.Join(h.BodiesDir, hash+extension(content.MimeType))
+	if err := writeBodyFile(name, b); err != nil {
+		log.Println(err)
+		return false
+	}
+	content.File = name
+	content.SHA256 = hash
+	content.Text = ""
+	content.Encoding = ""
+	return true
+}
+
+// bodyHash is the hex encoded SHA-256 of the body.
+func bodyHash(b body) (string, error) {
+	r, err := b.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// writeBodyFile writes the body to name unless it's already there.  It's
+// written to a temporary file first so a partly written file is never
+// mistaken for the body.
+func writeBodyFile(name string, b body) error {
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".body-")
+	if err != nil {
+		return err
+	}
+	// CreateTemp makes it readable by us alone.
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	r, err := b.open()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	_, err = io.Copy(f, r)
+	r.Close()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
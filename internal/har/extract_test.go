@@ -0,0 +1,86 @@
+package har_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/gopacket"
+)
+
+func TestExtractBodies(t *testing.T) {
+	dir := t.TempDir()
+	h := har.Har{Options: har.Options{BodiesDir: dir}}
+	conversation := func(path, mimeType string, body []byte) reader.Conversation {
+		u, err := url.Parse(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reader.Conversation{
+			Address: reader.ConversationAddress{
+				IP:   gopacket.NewFlow(1, []byte{127, 0, 0, 1}, []byte{127, 0, 0, 1}),
+				Port: gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90}),
+			},
+			Request: &http.Request{
+				Method: "GET", URL: u, Host: "localhost:8080", Proto: "HTTP/1.1",
+				ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{},
+			},
+			RequestBody: []byte{},
+			RequestSeen: []time.Time{time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)},
+			Response: &http.Response{
+				Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+				Header: http.Header{"Content-Type": {mimeType}},
+			},
+			ResponseBody: body,
+		}
+	}
+	json := []byte(`{"same":"body"}`)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00")
+	h.AddEntry(conversation("/first", "application/json; charset=utf-8", json))
+	h.AddEntry(conversation("/second", "application/json", json))
+	h.AddEntry(conversation("/image", "image/png", png))
+
+	hash := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+	expected := []struct{ hash, file string }{
+		{hash(json), filepath.Join(dir, hash(json)+".json")},
+		{hash(json), filepath.Join(dir, hash(json)+".json")},
+		{hash(png), filepath.Join(dir, hash(png)+".png")},
+	}
+	for i, e := range h.Log.Entries {
+		content := e.Response.Content
+		if content.SHA256 != expected[i].hash || content.File != expected[i].file {
+			t.Errorf("%s: expected %s, got %s %s", e.Request.URL, expected[i].file, content.SHA256, content.File)
+		}
+		if content.Text != "" || content.Encoding != "" {
+			t.Errorf("%s: expected no text, got %q %q", e.Request.URL, content.Text, content.Encoding)
+		}
+		if e.Request.Content.File != "" {
+			t.Errorf("%s: the empty request body shouldn't have been written", e.Request.URL)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected the repeated body to be written once, got %d files", len(files))
+	}
+	written, err := os.ReadFile(expected[2].file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != string(png) {
+		t.Errorf("Expected the body as it was, got %q", written)
+	}
+}
@@ -0,0 +1,47 @@
+package har
+
+import (
+	"sort"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// FCGIError is something the application wrote to stderr, or a note of
+// data lost from the capture, which doesn't have a time.
+type FCGIError struct {
+	Message string     `json:"message"`
+	Time    *time.Time `json:"time,omitempty"`
+}
+
+// extractFCGIParams lists the FastCGI params sorted by name.
+func extractFCGIParams(params map[string]string) []KeyValues {
+	if len(params) == 0 {
+		return nil
+	}
+	list := make([]KeyValues, 0, len(params))
+	for name, value := range params {
+		list = append(list, KeyValues{Name: name, Value: value})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// extractFCGIErrors lists the errors followed by the notes.
+func extractFCGIErrors(errors []reader.FCGIError, notes []string) []FCGIError {
+	var list []FCGIError
+	for _, e := range errors {
+		harError := FCGIError{Message: e.Message}
+		if !e.Seen.IsZero() {
+			seen := e.Seen
+			harError.Time = &seen
+		}
+		list = append(list, harError)
+	}
+	for _, note := range notes {
+		list = append(list, FCGIError{Message: note})
+	}
+	return list
+}
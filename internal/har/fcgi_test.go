@@ -0,0 +1,47 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarFCGIParams(t *testing.T) {
+	u, err := url.Parse("/index.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{
+			IP:   gopacket.NewFlow(1, []byte{10, 0, 0, 2}, []byte{10, 0, 0, 3}),
+			Port: gopacket.NewFlow(4, []byte{0xc3, 0x51}, []byte{0x23, 0x28}),
+		},
+		Request: &http.Request{
+			Method: "GET", URL: u, Host: "example.com", Proto: "HTTP/1.1",
+			ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{},
+		},
+		RequestSeen: []time.Time{time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)},
+		FastCGI:     true,
+		FCGIParams: map[string]string{
+			"SCRIPT_FILENAME": "/var/www/index.php",
+			"DOCUMENT_ROOT":   "/var/www",
+			"REMOTE_USER":     "colin",
+		},
+	})
+
+	expected := []har.KeyValues{
+		{Name: "DOCUMENT_ROOT", Value: "/var/www"},
+		{Name: "REMOTE_USER", Value: "colin"},
+		{Name: "SCRIPT_FILENAME", Value: "/var/www/index.php"},
+	}
+	if diff := cmp.Diff(h.Log.Entries[0].Request.FCGIParams, expected); diff != "" {
+		t.Errorf("Params don't match (-got +expected):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,29 @@
+package har
+
+import (
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// Chunk is one of the chunks of a body sent with chunked transfer encoding.
+type Chunk struct {
+	Size       int64     `json:"size"`
+	Extensions string    `json:"extensions,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+func extractChunks(chunks []reader.Chunk) []Chunk {
+	if len(chunks) == 0 {
+		return nil
+	}
+	harChunks := make([]Chunk, len(chunks))
+	for i, c := range chunks {
+		harChunks[i] = Chunk{
+			Size:       c.Size,
+			Extensions: c.Extensions,
+			Time:       c.Seen,
+		}
+	}
+	return harChunks
+}
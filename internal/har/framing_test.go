@@ -0,0 +1,50 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarFraming(t *testing.T) {
+	seen := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	u, err := url.Parse("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{
+			IP:   gopacket.NewFlow(1, []byte{127, 0, 0, 1}, []byte{127, 0, 0, 1}),
+			Port: gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90}),
+		},
+		Request: &http.Request{
+			Method: "GET", URL: u, Host: "localhost:8080", Proto: "HTTP/1.1",
+			ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{},
+		},
+		RequestSeen: []time.Time{seen},
+		Response: &http.Response{
+			Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header:  http.Header{"Trailer": {"X-Checksum, X-Missing"}},
+			Trailer: http.Header{"X-Checksum": {"abc"}, "X-Missing": nil},
+		},
+		ResponseBody:   []byte("Wikipedia"),
+		ResponseChunks: []reader.Chunk{{Size: 9, Extensions: "a=b", Seen: seen}, {Size: 0, Seen: seen}},
+	})
+
+	res := h.Log.Entries[0].Response
+	expectedChunks := []har.Chunk{{Size: 9, Extensions: "a=b", Time: seen}, {Size: 0, Time: seen}}
+	if diff := cmp.Diff(res.Chunks, expectedChunks); diff != "" {
+		t.Errorf("Chunks don't match (-got +expected):\n%s\n", diff)
+	}
+	expectedTrailers := []har.Header{{Name: "X-Checksum", Value: "abc"}}
+	if diff := cmp.Diff(res.Trailers, expectedTrailers); diff != "" {
+		t.Errorf("Trailers don't match (-got +expected):\n%s\n", diff)
+	}
+}
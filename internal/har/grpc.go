@@ -0,0 +1,71 @@
+package har
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/colinnewell/pcap2har-go/internal/grpc"
+)
+
+// GRPCMessage is a single message from a gRPC body.
+type GRPCMessage struct {
+	Compressed bool `json:"compressed"`
+	Size       int  `json:"size"`
+	// Text is the message as JSON when it could be decoded with the
+	// descriptors provided, otherwise Data contains the raw message.
+	Text string `json:"text,omitempty"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// addGRPCMessages splits a gRPC body into its messages.  When all of them
+// can be rendered as JSON the content text is replaced by the JSON, one
+// message per line.
+func (h *Har) addGRPCMessages(content *ContentInfo, path string, request bool, header http.Header, b body) {
+	r, err := b.open()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	messages, _ := grpc.ReadMessages(r)
+	r.Close()
+	encoding := header.Get("Grpc-Encoding")
+	texts := make([]string, 0, len(messages))
+	for _, m := range messages {
+		msg := GRPCMessage{Compressed: m.Compressed, Size: len(m.Data)}
+		if h.GRPCDecoder != nil {
+			if text, err := h.GRPCDecoder.JSON(path, request, encoding, m); err == nil {
+				msg.Text = text
+				texts = append(texts, text)
+			}
+		}
+		if msg.Text == "" {
+			msg.Data = m.Data
+		}
+		content.GRPCMessages = append(content.GRPCMessages, msg)
+	}
+	if len(texts) > 0 && len(texts) == len(messages) {
+		content.Text = strings.Join(texts, "\n")
+	}
+}
+
+// grpcStatus finds the status of the call.  It's normally in the trailers,
+// but a response without any messages can put it in the headers.
+func grpcStatus(res *http.Response) (*int, string) {
+	header := res.Trailer
+	if header.Get("Grpc-Status") == "" {
+		header = res.Header
+	}
+	status, err := strconv.Atoi(header.Get("Grpc-Status"))
+	if err != nil {
+		return nil, ""
+	}
+	// the message is percent encoded.
+	message := header.Get("Grpc-Message")
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	return &status, message
+}
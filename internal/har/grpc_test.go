@@ -0,0 +1,57 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarGRPC(t *testing.T) {
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x1f, 0x90})},
+		Request: &http.Request{
+			Method:     "POST",
+			URL:        &url.URL{Path: "/test.Greeter/Greet"},
+			Host:       "localhost:8080",
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			Header:     http.Header{"Content-Type": {"application/grpc"}},
+		},
+		Response: &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			Header:     http.Header{"Content-Type": {"application/grpc"}},
+			Trailer: http.Header{
+				"Grpc-Status":  {"3"},
+				"Grpc-Message": {"bad%20name"},
+			},
+		},
+		RequestBody:  []byte{0, 0, 0, 0, 2, 1, 2},
+		ResponseBody: []byte{},
+		RequestSeen:  []time.Time{{}},
+		ResponseSeen: []time.Time{{}},
+	})
+
+	entry := h.Log.Entries[0]
+	expected := []har.GRPCMessage{{Size: 2, Data: []byte{1, 2}}}
+	if diff := cmp.Diff(entry.Request.Content.GRPCMessages, expected); diff != "" {
+		t.Errorf("Messages don't match (-got +expected):\n%s\n", diff)
+	}
+	if entry.Response.GRPCStatus == nil || *entry.Response.GRPCStatus != 3 {
+		t.Errorf("Unexpected grpc status %v", entry.Response.GRPCStatus)
+	}
+	if entry.Response.GRPCMessage != "bad name" {
+		t.Errorf("Unexpected grpc message %q", entry.Response.GRPCMessage)
+	}
+}
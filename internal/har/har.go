@@ -1,14 +1,16 @@
 package har
 
 import (
-	"bytes"
 	"fmt"
-	"io"
+	"log"
+	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/colinnewell/pcap2har-go/internal/grpc"
 	"github.com/colinnewell/pcap2har-go/internal/reader"
 )
 
@@ -30,15 +32,16 @@ type PageTiming struct {
 	OnLoad        float64 `json:"onLoad"`
 }
 
+// EntryTimings are in milliseconds, with -1 for those that don't apply.
 type EntryTimings struct {
-	Blocked         int `json:"blocked"`
-	BlockedQueueing int `json:"_blocked_queueing"`
-	Connect         int `json:"connect"`
-	DNS             int `json:"dns"`
-	Receive         int `json:"receive"`
-	Send            int `json:"send"`
-	SSL             int `json:"ssl"`
-	Wait            int `json:"wait"`
+	Blocked         float64 `json:"blocked"`
+	BlockedQueueing float64 `json:"_blocked_queueing"`
+	Connect         float64 `json:"connect"`
+	DNS             float64 `json:"dns"`
+	Receive         float64 `json:"receive"`
+	Send            float64 `json:"send"`
+	SSL             float64 `json:"ssl"`
+	Wait            float64 `json:"wait"`
 }
 
 type Header KeyValues
@@ -61,13 +64,37 @@ type RequestInfo struct {
 	HeadersSize int         `json:"headersSize"`
 	BodySize    int         `json:"bodySize"`
 	Content     ContentInfo `json:"postData,omitempty"`
+	// Skipped are the parts of the stream that couldn't be decoded.
+	Skipped []SkippedBytes `json:"_skipped,omitempty"`
+	// Chunks the body was sent in, when they were recorded, and the
+	// Trailers sent after it.
+	Chunks   []Chunk  `json:"_chunks,omitempty"`
+	Trailers []Header `json:"_trailers,omitempty"`
+	// FCGIParams are the params sent with a FastCGI request that weren't
+	// used to make the HTTP request, or all of them when the raw params
+	// were kept.
+	FCGIParams []KeyValues `json:"_fcgiParams,omitempty"`
 }
 
 type ContentInfo struct {
-	MimeType string     `json:"mimeType"`
-	Size     int        `json:"size"`
-	Text     string     `json:"text"`
+	MimeType string `json:"mimeType"`
+	Size     int    `json:"size"`
+	// Compression is the number of bytes saved by compressing the body.
+	Compression int    `json:"compression,omitempty"`
+	Text        string `json:"text"`
+	// Encoding is base64 when the body was binary, so couldn't be
+	// represented as text.
+	Encoding string     `json:"encoding,omitempty"`
 	Params   []PostData `json:"params,omitempty"`
+	// DecodeError explains why the body couldn't be decompressed, in which
+	// case the text is the body as it was sent.
+	DecodeError string `json:"_decodeError,omitempty"`
+	// GRPCMessages are the messages from a gRPC body.
+	GRPCMessages []GRPCMessage `json:"_grpcMessages,omitempty"`
+	// File is where the body was written when the bodies are extracted,
+	// named by its SHA256, in which case the text is left empty.
+	File   string `json:"_file,omitempty"`
+	SHA256 string `json:"_sha256,omitempty"`
 }
 
 type KeyValues struct {
@@ -93,22 +120,53 @@ type ResponseInfo struct {
 	HeadersSize  int         `json:"headersSize"`
 	BodySize     int         `json:"bodySize"`
 	TransferSize int         `json:"_transferSize"`
-	FCGIErrors   []string    `json:"_fcgiErrors,omitempty"`
+	FCGIErrors   []FCGIError `json:"_fcgiErrors,omitempty"`
+	// FCGIAppStatus and FCGIProtocolStatus are what the application sent
+	// at the end of a FastCGI request, and FCGIAborted is set when the web
+	// server aborted it.
+	FCGIAppStatus      *int   `json:"_fcgiAppStatus,omitempty"`
+	FCGIProtocolStatus string `json:"_fcgiProtocolStatus,omitempty"`
+	FCGIAborted        bool   `json:"_fcgiAborted,omitempty"`
+	GRPCStatus         *int   `json:"_grpcStatus,omitempty"`
+	GRPCMessage        string `json:"_grpcMessage,omitempty"`
+	// Skipped are the parts of the stream that couldn't be decoded.
+	Skipped []SkippedBytes `json:"_skipped,omitempty"`
+	// Chunks the body was sent in, when they were recorded, and the
+	// Trailers sent after it.
+	Chunks   []Chunk  `json:"_chunks,omitempty"`
+	Trailers []Header `json:"_trailers,omitempty"`
 }
 
 type Entry struct {
 	// start of connection
 	StartedDateTime time.Time `json:"startedDateTime"`
-	// time taken in ns
-	// FIXME: perhaps add timings?
-	// for true timings we'd need dns time too.
-	// could set to -1 initially I guess
-	Time            int64        `json:"time"`
+	// time taken in ms, the total of the timings.
+	Time            float64      `json:"time"`
 	Request         RequestInfo  `json:"request"`
 	Response        ResponseInfo `json:"response"`
 	ServerIPAddress string       `json:"serverIPAddress"`
 	Connection      string       `json:"connection,omitempty"`
 	Timings         EntryTimings `json:"timings"`
+	// WebSocketMessages sent after the connection was upgraded.
+	WebSocketMessages []WebSocketMessage `json:"_webSocketMessages,omitempty"`
+	// EventStream has the events from a text/event-stream response.
+	EventStream []Event `json:"_eventStream,omitempty"`
+	// TLS has what was negotiated when the connection was over TLS.
+	TLS *TLSInfo `json:"_tls,omitempty"`
+	// Informational are the interim 1xx responses sent ahead of the
+	// response.
+	Informational []InformationalResponse `json:"_informational,omitempty"`
+	// Incomplete is set when packets were missing from the capture, so the
+	// bodies can't be trusted.  BytesMissing is how many bytes we know were
+	// lost.
+	Incomplete   bool  `json:"_incomplete,omitempty"`
+	BytesMissing int64 `json:"_bytesMissing,omitempty"`
+	// Truncated is set when the request or response body was cut short
+	// because it was bigger than the maximum body size.
+	Truncated bool `json:"_truncated,omitempty"`
+	// BackendCalls are the FastCGI requests made while handling the
+	// request.
+	BackendCalls []BackendCall `json:"_backendCalls,omitempty"`
 }
 
 type Har struct {
@@ -118,53 +176,118 @@ type Har struct {
 		Pages   []Page  `json:"pages"`
 		Entries []Entry `json:"entries"`
 	} `json:"log"`
+	Options `json:"-"`
+}
+
+// Options controls how the entries are built.  They should be set before any
+// entries are added.
+type Options struct {
+	// GRPCDecoder renders gRPC messages as JSON.  When it's nil the messages
+	// are left as they are.
+	GRPCDecoder *grpc.Decoder
+	// BodiesDir is a directory to write the bodies to rather than include
+	// them in the entries.  They're named by their SHA-256 so each body is
+	// only written once.
+	BodiesDir string
 }
 
 // AddEntry extracts info from HTTP conversations and turns them into a Har Entry.
 func (h *Har) AddEntry(v reader.Conversation) {
+	defer v.RemoveBodyFiles()
+	if entry, ok := h.entry(v); ok {
+		h.Log.Entries = append(h.Log.Entries, entry)
+	}
+}
+
+// entry turns the conversation into an Entry, returning false when there
+// isn't anything to show for it.
+func (h *Har) entry(v reader.Conversation) (Entry, bool) {
 	if v.Request == nil {
-		return
+		if v.TLS != nil {
+			return h.tlsEntry(v), true
+		}
+		return Entry{}, false
 	}
 	req := extractRequest(v)
-	startTime := v.RequestSeen[0]
-	var duration time.Duration
-	if len(v.ResponseSeen) > 0 {
-		duration = v.ResponseSeen[len(v.ResponseSeen)-1].Sub(startTime)
-	} else {
-		duration = v.RequestSeen[len(v.RequestSeen)-1].Sub(startTime)
-	}
-	resp := ResponseInfo{}
+	h.addContent(&req.Content, requestBody(v), v.Request.URL.Path, true, v.Request.Header)
+	startTime, timings := entryTimings(v)
+	resp := ResponseInfo{HeadersSize: -1}
 	if v.Response != nil {
 		mimeTypes, ok := v.Response.Header["Content-Type"]
 		var mimeType string
 		if ok {
 			mimeType = mimeTypes[0]
 		}
-		headers := extractHeaders(v.Response.Header)
+		headers := headerList(v.RawResponseHeader, v.ResponseHeaders, v.Response.Header)
+		if v.Response.ProtoMajor == 2 && v.ResponseHeaders == nil {
+			headers = append([]Header{{
+				Name: ":status", Value: strconv.Itoa(v.Response.StatusCode),
+			}}, headers...)
+		}
 		cookieInfo := extractCookies(v.Response.Cookies())
+		resBody := responseBody(v)
 		resp = ResponseInfo{
 			Content: ContentInfo{
-				Size:     len(v.ResponseBody),
-				MimeType: mimeType,
-				Text:     string(v.ResponseBody),
+				Size:        int(resBody.size()),
+				Compression: compression(resBody, v.ResponseSize),
+				MimeType:    mimeType,
+				DecodeError: v.ResponseDecodeError,
 			},
-			Cookies:     cookieInfo,
-			Headers:     headers,
-			HTTPVersion: v.Response.Proto,
-			StatusText:  v.Response.Status,
-			Status:      v.Response.StatusCode,
-			FCGIErrors:  v.Errors,
+			Cookies:      cookieInfo,
+			Headers:      headers,
+			HeadersSize:  headersSize(v.RawResponseHeader, v.ResponseSize),
+			BodySize:     int(v.ResponseSize.Body),
+			TransferSize: int(v.ResponseSize.Transfer),
+			HTTPVersion:  v.Response.Proto,
+			StatusText:   v.Response.Status,
+			Status:       v.Response.StatusCode,
+			Chunks:       extractChunks(v.ResponseChunks),
+			Trailers:     extractHeaders(v.Response.Trailer),
+		}
+		h.addContent(&resp.Content, resBody, v.Request.URL.Path, false, v.Response.Header)
+		if grpc.IsGRPC(mimeType) {
+			resp.GRPCStatus, resp.GRPCMessage = grpcStatus(v.Response)
 		}
 	}
+	resp.Skipped = extractSkipped(v.ResponseSkipped)
+	resp.FCGIErrors = extractFCGIErrors(v.FCGIErrors, v.Errors)
+	resp.FCGIAppStatus = v.FCGIAppStatus
+	resp.FCGIProtocolStatus = v.FCGIProtocolStatus
+	resp.FCGIAborted = v.FCGIAborted
 	entry := Entry{
-		Request:         req,
-		Response:        resp,
-		StartedDateTime: startTime,
-		Time:            duration.Nanoseconds(),
-		ServerIPAddress: v.Address.IP.Dst().String(),
-		Timings:         EntryTimings{-1, -1, -1, -1, -1, -1, -1, -1},
+		Request:           req,
+		Response:          resp,
+		StartedDateTime:   startTime,
+		Time:              timings.total(),
+		ServerIPAddress:   v.Address.IP.Dst().String(),
+		Timings:           timings,
+		WebSocketMessages: extractWebSocketMessages(v.WebSocketMessages),
+		EventStream:       extractEvents(v.Events),
+		TLS:               extractTLSInfo(v.TLS),
+		Informational:     extractInformational(v.Informational),
+		Incomplete:        v.Incomplete,
+		BytesMissing:      v.BytesMissing,
+		Truncated:         v.RequestTruncated || v.ResponseTruncated,
+		BackendCalls:      extractBackendCalls(v.BackendCalls),
+	}
+	return entry, true
+}
+
+// addContent fills in the text of the body, or writes it to BodiesDir when
+// that's set.  gRPC bodies are split into their messages too.
+func (h *Har) addContent(content *ContentInfo, b body, path string, request bool, header http.Header) {
+	if grpc.IsGRPC(content.MimeType) {
+		h.addGRPCMessages(content, path, request, header, b)
+	}
+	if h.extractBody(content, b) || content.Text != "" {
+		return
+	}
+	text, encoding, err := bodyText(b, content.MimeType)
+	if err != nil {
+		log.Println(err)
+		return
 	}
-	h.Log.Entries = append(h.Log.Entries, entry)
+	content.Text, content.Encoding = text, encoding
 }
 
 // FinaliseAndSort sort the requests by time and fill in the summary structures
@@ -200,10 +323,17 @@ func extractCookies(cookies []*http.Cookie) []Cookie {
 	return cookieInfo
 }
 
+// extractHeaders lists the parsed headers when we don't have them as they
+// were sent.  They're sorted by name so the output is consistent.
 func extractHeaders(header http.Header) []Header {
+	names := make([]string, 0, len(header))
+	for k := range header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
 	var headers []Header
-	for k, values := range header {
-		for _, v := range values {
+	for _, k := range names {
+		for _, v := range header[k] {
 			headers = append(headers, Header{Name: k, Value: v})
 		}
 	}
@@ -211,24 +341,22 @@ func extractHeaders(header http.Header) []Header {
 }
 
 func extractRequest(v reader.Conversation) RequestInfo {
-	reqheaders := extractHeaders(v.Request.Header)
-	if v.Request.Host != "" {
-		reqheaders = append(reqheaders, Header{
-			Name: "Host", Value: v.Request.Host,
-		})
-	}
-	cookieInfo := extractCookies(v.Request.Cookies())
-	var queryString []KeyValues
-	for k, values := range v.Request.URL.Query() {
-		for _, v := range values {
-			queryString = append(queryString, KeyValues{Name: k, Value: v})
+	reqheaders := headerList(v.RawRequestHeader, v.RequestHeaders, v.Request.Header)
+	if v.RawRequestHeader == nil && v.RequestHeaders == nil {
+		if v.Request.Host != "" && v.Request.ProtoMajor != 2 {
+			reqheaders = append(reqheaders, Header{
+				Name: "Host", Value: v.Request.Host,
+			})
 		}
 	}
+	cookieInfo := extractCookies(v.Request.Cookies())
+	queryString := queryParams(v.Request.URL.RawQuery)
 	var mimeType string
 	mimeTypes, ok := v.Request.Header["Content-Type"]
 	if ok {
 		mimeType = mimeTypes[0]
 	}
+	reqBody := requestBody(v)
 	var params []PostData
 	processedMimeType := mimeType
 	if idx := strings.Index(processedMimeType, ";"); idx >= 0 {
@@ -236,67 +364,86 @@ func extractRequest(v reader.Conversation) RequestInfo {
 	}
 	switch processedMimeType {
 	case "application/x-www-form-urlencoded":
-		v.Request.Body = io.NopCloser(bytes.NewBuffer(v.RequestBody))
-		if err := v.Request.ParseForm(); err == nil {
-			for k, values := range v.Request.PostForm {
-				for _, v := range values {
-					params = append(params, PostData{Name: k, Value: v})
-				}
-			}
-		}
+		params = formParams(reqBody)
 	case "multipart/form-data":
-		// MultipartReader
-		v.Request.Body = io.NopCloser(bytes.NewBuffer(v.RequestBody))
-		err := v.Request.ParseMultipartForm(int64(len(v.RequestBody)))
-		if err == nil {
-			for k, values := range v.Request.PostForm {
-				for _, v := range values {
-					params = append(params, PostData{Name: k, Value: v})
-				}
-			}
-			for k, files := range v.Request.MultipartForm.File {
-				for _, f := range files {
-					file, err := f.Open()
-					var content []byte
-					if err == nil {
-						content, _ = io.ReadAll(file)
-					}
-					v := string(content)
-					mimeTypes, ok := f.Header["Content-Type"]
-					var partType string
-					if ok {
-						partType = mimeTypes[0]
-					}
-
-					params = append(params, PostData{
-						Name:        k,
-						Value:       v,
-						FileName:    f.Filename,
-						ContentType: partType,
-					})
-				}
-			}
-		}
+		params = multipartParams(mimeType, reqBody)
 	}
 	if v.Request.URL.Host == "" {
 		v.Request.URL.Host = v.Request.Host
 	}
+	if v.Request.URL.Host == "" {
+		// HTTP/1.0 clients don't have to send a Host header.
+		v.Request.URL.Host = serverHost(v)
+	}
 	if v.Request.TLS == nil {
 		v.Request.URL.Scheme = "http"
 	} else {
 		v.Request.URL.Scheme = "https"
 	}
-	return RequestInfo{
+	if v.Request.ProtoMajor == 2 && v.RequestHeaders == nil {
+		// HTTP/2 sends these as pseudo headers ahead of the rest.
+		reqheaders = append([]Header{
+			{Name: ":method", Value: v.Request.Method},
+			{Name: ":authority", Value: v.Request.Host},
+			{Name: ":scheme", Value: v.Request.URL.Scheme},
+			{Name: ":path", Value: v.Request.URL.RequestURI()},
+		}, reqheaders...)
+	}
+	info := RequestInfo{
 		Cookies:     cookieInfo,
 		Headers:     reqheaders,
+		HeadersSize: headersSize(v.RawRequestHeader, v.RequestSize),
+		BodySize:    int(v.RequestSize.Body),
 		Method:      v.Request.Method,
 		URL:         v.Request.URL.String(),
+		HTTPVersion: v.Request.Proto,
 		QueryString: queryString,
+		Skipped:     extractSkipped(v.RequestSkipped),
+		Chunks:      extractChunks(v.RequestChunks),
+		Trailers:    extractHeaders(v.Request.Trailer),
+		FCGIParams:  extractFCGIParams(v.FCGIParams),
 		Content: ContentInfo{
-			Size:     len(v.RequestBody),
-			MimeType: mimeType,
-			Text:     string(v.RequestBody),
-			Params:   params,
+			Size:        int(reqBody.size()),
+			Compression: compression(reqBody, v.RequestSize),
+			MimeType:    mimeType,
+			Params:      params,
+			DecodeError: v.RequestDecodeError,
 		},
 	}
+	return info
+}
+
+// compression is the number of bytes saved by compressing the body, or 0 when
+// it wasn't compressed or we don't know how big it was on the wire.
+func compression(b body, size reader.MessageSize) int {
+	if size.Body == 0 || b.size() <= size.Body {
+		return 0
+	}
+	return int(b.size() - size.Body)
+}
+
+// headersSize is the size of the headers on the wire, or -1 when we don't
+// know it.
+func headersSize(raw []byte, size reader.MessageSize) int {
+	switch {
+	case raw != nil:
+		return len(raw)
+	case size.Header > 0:
+		return int(size.Header)
+	}
+	return -1
+}
+
+// serverHost is the name of the server the client connected to, falling back
+// to the IP address when we didn't see it looked up.
+func serverHost(v reader.Conversation) string {
+	host := v.Hostname
+	if host == "" {
+		host = v.Address.IP.Dst().String()
+	}
+	port := v.Address.Port.Dst().String()
+	if (port == "80" && v.Request.TLS == nil) || (port == "443" && v.Request.TLS != nil) {
+		return host
+	}
+	return net.JoinHostPort(host, port)
 }
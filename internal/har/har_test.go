@@ -100,7 +100,7 @@ func TestHarRequestOnly(t *testing.T) {
 		`        "request": {`,
 		`          "method": "GET",`,
 		`          "url": "http://localhost:3000/test.html?q=3\u0026v=4",`,
-		`          "httpVersion": "",`,
+		`          "httpVersion": "HTTP/1.0",`,
 		`          "headers": [`,
 		"            {",
 		`              "name": "Host",`,
@@ -118,7 +118,7 @@ func TestHarRequestOnly(t *testing.T) {
 		"            }",
 		"          ],",
 		`          "cookies": [],`,
-		`          "headersSize": 0,`,
+		`          "headersSize": -1,`,
 		`          "bodySize": 0,`,
 		`          "postData": {`,
 		`            "mimeType": "",`,
@@ -138,7 +138,7 @@ func TestHarRequestOnly(t *testing.T) {
 		`            "text": ""`,
 		"          },",
 		`          "redirectURL": "",`,
-		`          "headersSize": 0,`,
+		`          "headersSize": -1,`,
 		`          "bodySize": 0,`,
 		`          "_transferSize": 0`,
 		"        },",
@@ -149,7 +149,7 @@ func TestHarRequestOnly(t *testing.T) {
 		`          "connect": -1,`,
 		`          "dns": -1,`,
 		`          "receive": -1,`,
-		`          "send": -1,`,
+		`          "send": 0,`,
 		`          "ssl": -1,`,
 		`          "wait": -1`,
 		"        }",
@@ -245,7 +245,7 @@ func TestHarFullConversation(t *testing.T) {
 		`        "request": {`,
 		`          "method": "GET",`,
 		`          "url": "http://localhost:3000/test.html?q=3\u0026v=4",`,
-		`          "httpVersion": "",`,
+		`          "httpVersion": "HTTP/1.0",`,
 		`          "headers": [`,
 		"            {",
 		`              "name": "Host",`,
@@ -263,7 +263,7 @@ func TestHarFullConversation(t *testing.T) {
 		"            }",
 		"          ],",
 		`          "cookies": [],`,
-		`          "headersSize": 0,`,
+		`          "headersSize": -1,`,
 		`          "bodySize": 0,`,
 		`          "postData": {`,
 		`            "mimeType": "",`,
@@ -283,7 +283,7 @@ func TestHarFullConversation(t *testing.T) {
 		`            "text": "response body"`,
 		"          },",
 		`          "redirectURL": "",`,
-		`          "headersSize": 0,`,
+		`          "headersSize": -1,`,
 		`          "bodySize": 0,`,
 		`          "_transferSize": 0`,
 		"        },",
@@ -293,10 +293,10 @@ func TestHarFullConversation(t *testing.T) {
 		`          "_blocked_queueing": -1,`,
 		`          "connect": -1,`,
 		`          "dns": -1,`,
-		`          "receive": -1,`,
-		`          "send": -1,`,
+		`          "receive": 0,`,
+		`          "send": 0,`,
 		`          "ssl": -1,`,
-		`          "wait": -1`,
+		`          "wait": 0`,
 		"        }",
 		"      }",
 		"    ]",
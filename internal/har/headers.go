@@ -0,0 +1,110 @@
+package har
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// headerList lists the headers as they were sent when we have them, either
+// as the raw header block or as the fields, and otherwise the parsed
+// headers.
+func headerList(raw []byte, fields []reader.HeaderField, header http.Header) []Header {
+	switch {
+	case raw != nil:
+		fields = reader.WireHeaders(raw)
+	case fields == nil:
+		return extractHeaders(header)
+	}
+	headers := make([]Header, len(fields))
+	for i, f := range fields {
+		headers[i] = Header{Name: f.Name, Value: f.Value}
+	}
+	return headers
+}
+
+// queryParams lists the parameters from a query string, or a url encoded
+// form, in the order they were sent.
+func queryParams(query string) []KeyValues {
+	var params []KeyValues
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		params = append(params, KeyValues{Name: unescape(name), Value: unescape(value)})
+	}
+	return params
+}
+
+// unescape decodes a query string component, leaving it as it is if it's
+// not valid.
+func unescape(s string) string {
+	if u, err := url.QueryUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// formParams lists the fields from a url encoded form in the order they were
+// sent.
+func formParams(b body) []PostData {
+	r, err := b.open()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer r.Close()
+	var params []PostData
+	br := bufio.NewReader(r)
+	for {
+		// read a field at a time so the whole form isn't needed at once.
+		pair, err := br.ReadString('&')
+		for _, p := range queryParams(pair) {
+			params = append(params, PostData{Name: p.Name, Value: p.Value})
+		}
+		if err != nil {
+			return params
+		}
+	}
+}
+
+// multipartParams lists the parts of a multipart form in the order they were
+// sent.
+func multipartParams(mimeType string, b body) []PostData {
+	_, mediaParams, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return nil
+	}
+	r, err := b.open()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer r.Close()
+	mr := multipart.NewReader(r, mediaParams["boundary"])
+	var params []PostData
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return params
+		}
+		content, _ := io.ReadAll(part)
+		param := PostData{
+			Name:     part.FormName(),
+			Value:    string(content),
+			FileName: part.FileName(),
+		}
+		if param.FileName != "" {
+			param.ContentType = part.Header.Get("Content-Type")
+		}
+		params = append(params, param)
+	}
+}
@@ -0,0 +1,212 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarWireOrder(t *testing.T) {
+	rawRequest := strings.Join([]string{
+		"POST /form?z=1&a=2&z=3&sp=a+b HTTP/1.1",
+		"host: example.test",
+		"X-Custom-ID: first",
+		"x-custom-id: second",
+		"Content-Type: application/x-www-form-urlencoded",
+		"X-Folded: one",
+		"  two",
+		"",
+		"",
+	}, "\r\n")
+	rawResponse := "HTTP/1.1 200 OK\r\nserver: test\r\nContent-Length: 0\r\n\r\n"
+	u, err := url.Parse("/form?z=1&a=2&z=3&sp=a+b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x0, 0x50})},
+		Request: &http.Request{
+			Method: "POST",
+			URL:    u,
+			Host:   "example.test",
+			Proto:  "HTTP/1.1",
+			Header: http.Header{
+				"X-Custom-Id":  {"first", "second"},
+				"Content-Type": {"application/x-www-form-urlencoded"},
+				"X-Folded":     {"one two"},
+			},
+		},
+		RawRequestHeader: []byte(rawRequest),
+		RequestBody:      []byte("name=b&name=a&x=%3D"),
+		RequestSeen:      []time.Time{{}},
+		Response: &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Proto:      "HTTP/1.1",
+			Header:     http.Header{"Server": {"test"}, "Content-Length": {"0"}},
+		},
+		RawResponseHeader: []byte(rawResponse),
+		ResponseSeen:      []time.Time{{}},
+	})
+
+	entry := h.Log.Entries[0]
+	expectedHeaders := []har.Header{
+		{Name: "host", Value: "example.test"},
+		{Name: "X-Custom-ID", Value: "first"},
+		{Name: "x-custom-id", Value: "second"},
+		{Name: "Content-Type", Value: "application/x-www-form-urlencoded"},
+		{Name: "X-Folded", Value: "one two"},
+	}
+	if diff := cmp.Diff(entry.Request.Headers, expectedHeaders); diff != "" {
+		t.Errorf("Request headers don't match (-got +expected):\n%s\n", diff)
+	}
+	expectedQuery := []har.KeyValues{
+		{Name: "z", Value: "1"},
+		{Name: "a", Value: "2"},
+		{Name: "z", Value: "3"},
+		{Name: "sp", Value: "a b"},
+	}
+	if diff := cmp.Diff(entry.Request.QueryString, expectedQuery); diff != "" {
+		t.Errorf("Query string doesn't match (-got +expected):\n%s\n", diff)
+	}
+	expectedParams := []har.PostData{
+		{Name: "name", Value: "b"},
+		{Name: "name", Value: "a"},
+		{Name: "x", Value: "="},
+	}
+	if diff := cmp.Diff(entry.Request.Content.Params, expectedParams); diff != "" {
+		t.Errorf("Form params don't match (-got +expected):\n%s\n", diff)
+	}
+	if entry.Request.HeadersSize != len(rawRequest) {
+		t.Errorf("Expected request headersSize %d, got %d", len(rawRequest), entry.Request.HeadersSize)
+	}
+
+	expectedResponseHeaders := []har.Header{
+		{Name: "server", Value: "test"},
+		{Name: "Content-Length", Value: "0"},
+	}
+	if diff := cmp.Diff(entry.Response.Headers, expectedResponseHeaders); diff != "" {
+		t.Errorf("Response headers don't match (-got +expected):\n%s\n", diff)
+	}
+	if entry.Response.HeadersSize != len(rawResponse) {
+		t.Errorf("Expected response headersSize %d, got %d", len(rawResponse), entry.Response.HeadersSize)
+	}
+}
+
+func TestHarMultipartOrder(t *testing.T) {
+	body := strings.Join([]string{
+		"--xyz",
+		`Content-Disposition: form-data; name="title"`,
+		"",
+		"hello",
+		"--xyz",
+		`Content-Disposition: form-data; name="upload"; filename="a.txt"`,
+		"Content-Type: text/plain",
+		"",
+		"file contents",
+		"--xyz",
+		`Content-Disposition: form-data; name="after"`,
+		"",
+		"last",
+		"--xyz--",
+		"",
+	}, "\r\n")
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x0, 0x50})},
+		Request: &http.Request{
+			Method: "POST",
+			URL:    &url.URL{Path: "/upload"},
+			Host:   "example.test",
+			Proto:  "HTTP/1.1",
+			Header: http.Header{"Content-Type": {"multipart/form-data; boundary=xyz"}},
+		},
+		RequestBody: []byte(body),
+		RequestSeen: []time.Time{{}},
+	})
+
+	expected := []har.PostData{
+		{Name: "title", Value: "hello"},
+		{Name: "upload", Value: "file contents", FileName: "a.txt", ContentType: "text/plain"},
+		{Name: "after", Value: "last"},
+	}
+	if diff := cmp.Diff(h.Log.Entries[0].Request.Content.Params, expected); diff != "" {
+		t.Errorf("Multipart params don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestHarHeaderFields(t *testing.T) {
+	u, err := url.Parse("https://example.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x1, 0xbb})},
+		Request: &http.Request{
+			Method:     "GET",
+			URL:        u,
+			Host:       "example.test",
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			Header:     http.Header{"User-Agent": {"test"}, "Accept": {"*/*"}},
+		},
+		RequestHeaders: []reader.HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":authority", Value: "example.test"},
+			{Name: ":scheme", Value: "https"},
+			{Name: ":path", Value: "/"},
+			{Name: "user-agent", Value: "test"},
+			{Name: "accept", Value: "*/*"},
+		},
+		RequestSeen: []time.Time{{}},
+		Response: &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			Header:     http.Header{"Server": {"test"}, "Content-Type": {"text/plain"}},
+		},
+		ResponseHeaders: []reader.HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: "server", Value: "test"},
+			{Name: "content-type", Value: "text/plain"},
+		},
+		ResponseSeen: []time.Time{{}},
+	})
+
+	entry := h.Log.Entries[0]
+	expectedRequest := []har.Header{
+		{Name: ":method", Value: "GET"},
+		{Name: ":authority", Value: "example.test"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/"},
+		{Name: "user-agent", Value: "test"},
+		{Name: "accept", Value: "*/*"},
+	}
+	if diff := cmp.Diff(entry.Request.Headers, expectedRequest); diff != "" {
+		t.Errorf("Request headers don't match (-got +expected):\n%s\n", diff)
+	}
+	expectedResponse := []har.Header{
+		{Name: ":status", Value: "200"},
+		{Name: "server", Value: "test"},
+		{Name: "content-type", Value: "text/plain"},
+	}
+	if diff := cmp.Diff(entry.Response.Headers, expectedResponse); diff != "" {
+		t.Errorf("Response headers don't match (-got +expected):\n%s\n", diff)
+	}
+}
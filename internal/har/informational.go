@@ -0,0 +1,38 @@
+package har
+
+import (
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// InformationalResponse is an interim 1xx response, like a 100 Continue,
+// sent ahead of the final response.
+type InformationalResponse struct {
+	Status      int       `json:"status"`
+	StatusText  string    `json:"statusText"`
+	HTTPVersion string    `json:"httpVersion"`
+	Headers     []Header  `json:"headers"`
+	Time        time.Time `json:"time"`
+}
+
+func extractInformational(responses []reader.InformationalResponse) []InformationalResponse {
+	if len(responses) == 0 {
+		return nil
+	}
+	harResponses := make([]InformationalResponse, len(responses))
+	for i, r := range responses {
+		var seen time.Time
+		if len(r.Seen) > 0 {
+			seen = r.Seen[0]
+		}
+		harResponses[i] = InformationalResponse{
+			Status:      r.Response.StatusCode,
+			StatusText:  r.Response.Status,
+			HTTPVersion: r.Response.Proto,
+			Headers:     headerList(r.RawHeader, nil, r.Response.Header),
+			Time:        seen,
+		}
+	}
+	return harResponses
+}
@@ -0,0 +1,60 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarInformational(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x1f, 0x90})},
+		Request: &http.Request{
+			Method: "POST",
+			URL:    &url.URL{Path: "/upload"},
+			Host:   "localhost:8080",
+			Proto:  "HTTP/1.1",
+			Header: http.Header{"Expect": {"100-continue"}},
+		},
+		RequestSeen: []time.Time{start},
+		Informational: []reader.InformationalResponse{{
+			Response: &http.Response{
+				Status:     "100 Continue",
+				StatusCode: 100,
+				Proto:      "HTTP/1.1",
+				Header:     http.Header{},
+			},
+			Seen: []time.Time{start.Add(time.Millisecond)},
+		}},
+		Response: &http.Response{
+			Status:     "201 Created",
+			StatusCode: 201,
+			Proto:      "HTTP/1.1",
+			Header:     http.Header{},
+		},
+		ResponseSeen: []time.Time{start.Add(2 * time.Millisecond)},
+	})
+
+	expected := []har.InformationalResponse{{
+		Status:      100,
+		StatusText:  "100 Continue",
+		HTTPVersion: "HTTP/1.1",
+		Time:        start.Add(time.Millisecond),
+	}}
+	if diff := cmp.Diff(h.Log.Entries[0].Informational, expected); diff != "" {
+		t.Errorf("Informational responses don't match (-got +expected):\n%s\n", diff)
+	}
+	if h.Log.Entries[0].Response.Status != 201 {
+		t.Errorf("Expected the final response, got %d", h.Log.Entries[0].Response.Status)
+	}
+}
@@ -0,0 +1,95 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/gopacket"
+)
+
+func TestHarSizes(t *testing.T) {
+	rawResponse := "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n"
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x0, 0x50})},
+		Request: &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Host:   "example.test",
+			Proto:  "HTTP/1.1",
+			Header: http.Header{},
+		},
+		RawRequestHeader: []byte("GET / HTTP/1.1\r\nHost: example.test\r\n\r\n"),
+		RequestSeen:      []time.Time{{}},
+		RequestSize:      reader.MessageSize{Transfer: 38},
+		Response: &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Proto:      "HTTP/1.1",
+			Header:     http.Header{"Content-Encoding": {"gzip"}},
+		},
+		RawResponseHeader: []byte(rawResponse),
+		ResponseBody:      []byte(strings.Repeat("a", 1000)),
+		ResponseSeen:      []time.Time{{}},
+		ResponseSize:      reader.MessageSize{Body: 30, Transfer: int64(len(rawResponse)) + 40},
+	})
+
+	entry := h.Log.Entries[0]
+	if entry.Request.HeadersSize != 38 || entry.Request.BodySize != 0 {
+		t.Errorf("Unexpected request sizes: headers %d, body %d", entry.Request.HeadersSize, entry.Request.BodySize)
+	}
+	res := entry.Response
+	if res.HeadersSize != len(rawResponse) {
+		t.Errorf("Expected response headersSize %d, got %d", len(rawResponse), res.HeadersSize)
+	}
+	if res.BodySize != 30 {
+		t.Errorf("Expected bodySize 30, got %d", res.BodySize)
+	}
+	if res.Content.Size != 1000 || res.Content.Compression != 970 {
+		t.Errorf("Expected content size 1000 with 970 saved, got %d with %d saved", res.Content.Size, res.Content.Compression)
+	}
+	if res.TransferSize != len(rawResponse)+40 {
+		t.Errorf("Expected _transferSize %d, got %d", len(rawResponse)+40, res.TransferSize)
+	}
+}
+
+func TestHarHeadersSizeWithoutRawHeader(t *testing.T) {
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x0, 0x50})},
+		Request: &http.Request{
+			Method:     "GET",
+			URL:        &url.URL{Path: "/"},
+			Host:       "example.test",
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			Header:     http.Header{},
+		},
+		RequestSeen: []time.Time{{}},
+		RequestSize: reader.MessageSize{Transfer: 29, Header: 20},
+		Response: &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Proto:      "HTTP/1.0",
+			Header:     http.Header{},
+		},
+		ResponseSeen: []time.Time{{}},
+	})
+
+	entry := h.Log.Entries[0]
+	if entry.Request.HeadersSize != 20 {
+		t.Errorf("Expected request headersSize 20, got %d", entry.Request.HeadersSize)
+	}
+	if entry.Response.HeadersSize != -1 {
+		t.Errorf("Expected response headersSize -1, got %d", entry.Response.HeadersSize)
+	}
+}
@@ -0,0 +1,31 @@
+package har
+
+import (
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// SkippedBytes is a part of a stream that couldn't be decoded so was skipped
+// over.
+type SkippedBytes struct {
+	// Offset from the start of the stream.
+	Offset int64     `json:"offset"`
+	Length int64     `json:"length"`
+	Time   time.Time `json:"time"`
+}
+
+func extractSkipped(skipped []reader.SkippedBytes) []SkippedBytes {
+	if len(skipped) == 0 {
+		return nil
+	}
+	harSkipped := make([]SkippedBytes, len(skipped))
+	for i, s := range skipped {
+		harSkipped[i] = SkippedBytes{
+			Offset: s.Offset,
+			Length: s.Length,
+			Time:   s.Seen,
+		}
+	}
+	return harSkipped
+}
@@ -0,0 +1,65 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarSkipped(t *testing.T) {
+	seen := time.Unix(1600000000, 0)
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x1f, 0x90})},
+		Request: &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Host:   "localhost:8080",
+			Proto:  "HTTP/1.1",
+			Header: http.Header{},
+		},
+		RequestSeen:     []time.Time{seen},
+		RequestSkipped:  []reader.SkippedBytes{{Offset: 100, Length: 20, Seen: seen}},
+		ResponseSkipped: []reader.SkippedBytes{{Offset: 0, Length: 5, Seen: seen}},
+	})
+
+	entry := h.Log.Entries[0]
+	if diff := cmp.Diff(entry.Request.Skipped, []har.SkippedBytes{{Offset: 100, Length: 20, Time: seen}}); diff != "" {
+		t.Errorf("Request skipped doesn't match (-got +expected):\n%s\n", diff)
+	}
+	if diff := cmp.Diff(entry.Response.Skipped, []har.SkippedBytes{{Offset: 0, Length: 5, Time: seen}}); diff != "" {
+		t.Errorf("Response skipped doesn't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestHarIncomplete(t *testing.T) {
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x1f, 0x90})},
+		Request: &http.Request{
+			Method: "POST",
+			URL:    &url.URL{Path: "/"},
+			Host:   "localhost:8080",
+			Proto:  "HTTP/1.1",
+			Header: http.Header{},
+		},
+		RequestSeen:  []time.Time{time.Unix(1600000000, 0)},
+		Incomplete:   true,
+		BytesMissing: 1460,
+	})
+
+	entry := h.Log.Entries[0]
+	if !entry.Incomplete || entry.BytesMissing != 1460 {
+		t.Errorf("Expected an incomplete entry missing 1460 bytes, got %v %d", entry.Incomplete, entry.BytesMissing)
+	}
+}
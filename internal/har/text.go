@@ -0,0 +1,123 @@
+package har
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// bodyText returns the body as text for the HAR, converting it to UTF-8 when
+// the mime type declares another charset.  Binary bodies can't be
+// represented as text so they're base64 encoded, in which case the encoding
+// is "base64".  The text is built as the body is read, so a body spilled to
+// a temporary file is never held in memory other than as the text.
+func bodyText(b body, mimeType string) (text, encoding string, err error) {
+	if b.size() == 0 {
+		return "", "", nil
+	}
+	if charset := declaredCharset(mimeType); charset != "" {
+		if e, err := htmlindex.Get(charset); err == nil {
+			converted, err := readText(b, e.NewDecoder().Reader)
+			if err == nil {
+				if ok, _ := isText(strings.NewReader(converted)); ok {
+					return converted, "", nil
+				}
+			}
+		}
+	}
+	r, err := b.open()
+	if err != nil {
+		return "", "", err
+	}
+	ok, err := isText(r)
+	r.Close()
+	if err != nil {
+		return "", "", err
+	}
+	if ok {
+		text, err := readText(b, nil)
+		return text, "", err
+	}
+	text, err = base64Text(b)
+	return text, "base64", err
+}
+
+// readText reads the body into a string, passing it through decode first
+// when that's set.
+func readText(b body, decode func(io.Reader) io.Reader) (string, error) {
+	r, err := b.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	var src io.Reader = r
+	if decode != nil {
+		src = decode(r)
+	}
+	var text strings.Builder
+	text.Grow(int(b.size()))
+	if _, err := io.Copy(&text, src); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// base64Text base64 encodes the body as it's read.
+func base64Text(b body) (string, error) {
+	r, err := b.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	var text strings.Builder
+	text.Grow(base64.StdEncoding.EncodedLen(int(b.size())))
+	w := base64.NewEncoder(base64.StdEncoding, &text)
+	if _, err := io.Copy(w, r); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// declaredCharset returns the charset from the mime type, unless it's UTF-8
+// or a subset of it.
+func declaredCharset(mimeType string) string {
+	_, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ""
+	}
+	charset := strings.ToLower(params["charset"])
+	switch charset {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return ""
+	}
+	return charset
+}
+
+// isText checks the body is valid UTF-8 without any of the control
+// characters that suggest it's binary.
+func isText(r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	for {
+		c, size, err := br.ReadRune()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if c == utf8.RuneError && size == 1 {
+			return false, nil
+		}
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' && c != 0x1b {
+			return false, nil
+		}
+	}
+}
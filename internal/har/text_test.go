@@ -0,0 +1,62 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/gopacket"
+)
+
+func TestHarBodyText(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		text        string
+		encoding    string
+	}{
+		{"utf-8", "text/plain; charset=utf-8", []byte("café"), "café", ""},
+		{"no charset", "application/json", []byte(`{"a":1}`), `{"a":1}`, ""},
+		{"latin-1", "text/html; charset=ISO-8859-1", []byte("caf\xe9"), "café", ""},
+		{"shift_jis", "text/plain; charset=Shift_JIS", []byte("\x93\xfa\x96\x7b"), "日本", ""},
+		{"binary", "image/png", []byte("\x89PNG\r\n\x1a\n\x00"), "iVBORw0KGgoA", "base64"},
+		{"not utf-8", "application/octet-stream", []byte("\xff\xfe"), "//4=", "base64"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var h har.Har
+			h.AddEntry(reader.Conversation{
+				Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+					[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+					[]byte{0x23, 0x36}, []byte{0x0, 0x50})},
+				Request: &http.Request{
+					Method: "GET",
+					URL:    &url.URL{Path: "/"},
+					Host:   "example.test",
+					Proto:  "HTTP/1.1",
+					Header: http.Header{},
+				},
+				RequestSeen: []time.Time{{}},
+				Response: &http.Response{
+					Status:     "200 OK",
+					StatusCode: 200,
+					Proto:      "HTTP/1.1",
+					Header:     http.Header{"Content-Type": {test.contentType}},
+				},
+				ResponseBody: test.body,
+				ResponseSeen: []time.Time{{}},
+			})
+			content := h.Log.Entries[0].Response.Content
+			if content.Text != test.text || content.Encoding != test.encoding {
+				t.Errorf("Expected %q (%q), got %q (%q)", test.text, test.encoding, content.Text, content.Encoding)
+			}
+			if content.Size != len(test.body) {
+				t.Errorf("Expected size %d, got %d", len(test.body), content.Size)
+			}
+		})
+	}
+}
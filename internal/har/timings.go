@@ -0,0 +1,59 @@
+package har
+
+import (
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// entryTimings works out the timings from when the packets were seen,
+// returning them along with the time the entry started.
+func entryTimings(v reader.Conversation) (time.Time, EntryTimings) {
+	timings := EntryTimings{-1, -1, -1, -1, -1, -1, -1, -1}
+	requestStart := v.RequestSeen[0]
+	requestEnd := v.RequestSeen[len(v.RequestSeen)-1]
+	start := requestStart
+
+	if hs := v.Handshake; hs != nil && hs.Complete() && !hs.ACK.After(requestStart) {
+		timings.Connect = milliseconds(hs.ACK.Sub(hs.SYN))
+		start = hs.SYN
+	}
+	if tls := v.TLS; v.TLSHandshake && tls != nil && !tls.Started.IsZero() &&
+		!tls.Finished.IsZero() && !tls.Finished.After(requestStart) {
+		timings.SSL = milliseconds(tls.Finished.Sub(tls.Started))
+		if timings.Connect < 0 {
+			start = tls.Started
+		}
+		// the connect time includes the TLS handshake.
+		timings.Connect = milliseconds(tls.Finished.Sub(start))
+	}
+	if lookup := v.DNS; lookup != nil && !lookup.Query.IsZero() {
+		timings.DNS = milliseconds(lookup.Answer.Sub(lookup.Query))
+		start = lookup.Query
+	}
+	timings.Send = milliseconds(requestEnd.Sub(requestStart))
+	if len(v.ResponseSeen) > 0 {
+		responseStart := v.ResponseSeen[0]
+		// the server can start responding before it has the whole
+		// request.
+		timings.Wait = milliseconds(max(responseStart.Sub(requestEnd), 0))
+		timings.Receive = milliseconds(v.ResponseSeen[len(v.ResponseSeen)-1].Sub(responseStart))
+	}
+	return start, timings
+}
+
+// total adds up the timings that apply.
+func (t EntryTimings) total() float64 {
+	var total float64
+	// _blocked_queueing is part of blocked.
+	for _, timing := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if timing > 0 {
+			total += timing
+		}
+	}
+	return total
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,106 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestHarTimings(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	conversation := reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x1f, 0x90})},
+		Request: &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Host:   "localhost:8080",
+			Proto:  "HTTP/1.1",
+			Header: http.Header{},
+		},
+		Response: &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Proto:      "HTTP/1.1",
+			Header:     http.Header{},
+		},
+		Handshake:    &reader.TCPHandshake{SYN: at(0), SYNACK: at(1), ACK: at(2)},
+		RequestSeen:  []time.Time{at(3), at(5)},
+		ResponseSeen: []time.Time{at(15), at(16), at(20)},
+	}
+	var h har.Har
+	h.AddEntry(conversation)
+	// later requests on the connection don't have to wait for it.
+	conversation.Handshake = nil
+	h.AddEntry(conversation)
+
+	first := h.Log.Entries[0]
+	if !first.StartedDateTime.Equal(at(0)) {
+		t.Errorf("Expected the first entry to start with the SYN, got %s", first.StartedDateTime)
+	}
+	if diff := cmp.Diff(first.Timings, har.EntryTimings{
+		Blocked: -1, BlockedQueueing: -1, DNS: -1, SSL: -1,
+		Connect: 2, Send: 2, Wait: 10, Receive: 5,
+	}); diff != "" {
+		t.Errorf("Timings don't match (-got +expected):\n%s\n", diff)
+	}
+	if first.Time != 19 {
+		t.Errorf("Expected a time of 19ms, got %v", first.Time)
+	}
+
+	second := h.Log.Entries[1]
+	if !second.StartedDateTime.Equal(at(3)) {
+		t.Errorf("Expected the second entry to start with the request, got %s", second.StartedDateTime)
+	}
+	if second.Timings.Connect != -1 || second.Time != 17 {
+		t.Errorf("Unexpected timings %+v, time %v", second.Timings, second.Time)
+	}
+}
+
+func TestHarDNS(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	portFlow, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(50000),
+		layers.NewTCPPortEndpoint(8080))
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{
+			IP:   gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}),
+			Port: portFlow,
+		},
+		// an HTTP/1.0 request without a Host header.
+		Request: &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Proto:  "HTTP/1.0",
+			Header: http.Header{},
+		},
+		Hostname:    "example.test",
+		DNS:         &reader.DNSLookup{Name: "example.test", Query: at(0), Answer: at(4)},
+		RequestSeen: []time.Time{at(10)},
+	})
+
+	entry := h.Log.Entries[0]
+	if entry.Request.URL != "http://example.test:8080/" {
+		t.Errorf("Unexpected URL %s", entry.Request.URL)
+	}
+	if entry.Timings.DNS != 4 {
+		t.Errorf("Expected a DNS time of 4ms, got %v", entry.Timings.DNS)
+	}
+	if !entry.StartedDateTime.Equal(at(0)) {
+		t.Errorf("Expected the entry to start with the lookup, got %s", entry.StartedDateTime)
+	}
+}
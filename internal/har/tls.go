@@ -0,0 +1,67 @@
+package har
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/colinnewell/pcap2har-go/internal/tlsdecode"
+)
+
+// TLSInfo has the details negotiated for a TLS connection.
+type TLSInfo struct {
+	ServerName  string `json:"serverName,omitempty"`
+	Version     string `json:"version,omitempty"`
+	CipherSuite string `json:"cipherSuite,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+	// Subject and expiry of the certificate the server presented.
+	CertificateSubject string     `json:"certificateSubject,omitempty"`
+	CertificateExpiry  *time.Time `json:"certificateExpiry,omitempty"`
+}
+
+func extractTLSInfo(info *tlsdecode.Info) *TLSInfo {
+	if info == nil {
+		return nil
+	}
+	t := TLSInfo{
+		ServerName: info.ServerName,
+		ALPN:       info.ALPN,
+	}
+	if info.Version != 0 {
+		t.Version = tls.VersionName(info.Version)
+	}
+	if info.CipherSuite != 0 {
+		t.CipherSuite = tls.CipherSuiteName(info.CipherSuite)
+	}
+	if cert := info.Certificate; cert != nil {
+		t.CertificateSubject = cert.Subject.String()
+		expiry := cert.NotAfter
+		t.CertificateExpiry = &expiry
+	}
+	return &t
+}
+
+// tlsEntry is the entry for a TLS connection we couldn't decrypt so that it
+// at least shows up in the timeline.
+func (h *Har) tlsEntry(v reader.Conversation) Entry {
+	host := v.TLS.ServerName
+	if host == "" {
+		host = v.Address.IP.Dst().String()
+	}
+	if port := v.Address.Port.Dst().String(); port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	startTime, timings := entryTimings(v)
+	return Entry{
+		Request: RequestInfo{
+			URL:         "https://" + host + "/",
+			HTTPVersion: v.TLS.ALPN,
+		},
+		StartedDateTime: startTime,
+		Time:            timings.total(),
+		ServerIPAddress: v.Address.IP.Dst().String(),
+		Timings:         timings,
+		TLS:             extractTLSInfo(v.TLS),
+	}
+}
@@ -0,0 +1,74 @@
+package har_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/colinnewell/pcap2har-go/internal/tlsdecode"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestHarTLSEntry(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	portFlow, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(50000),
+		layers.NewTCPPortEndpoint(443))
+	var h har.Har
+	// a connection we couldn't decrypt.
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{
+			IP:   gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}),
+			Port: portFlow,
+		},
+		Handshake: &reader.TCPHandshake{SYN: at(0), SYNACK: at(1), ACK: at(2)},
+		TLS: &tlsdecode.Info{
+			ServerName:  "example.test",
+			Version:     tls.VersionTLS12,
+			CipherSuite: tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			ALPN:        "h2",
+			Certificate: &x509.Certificate{
+				Subject:  pkix.Name{CommonName: "example.test"},
+				NotAfter: expiry,
+			},
+			Started:  at(3),
+			Finished: at(10),
+		},
+		TLSHandshake: true,
+		RequestSeen:  []time.Time{at(10), at(12)},
+		ResponseSeen: []time.Time{at(20), at(25)},
+	})
+
+	entry := h.Log.Entries[0]
+	if entry.Request.URL != "https://example.test/" {
+		t.Errorf("Unexpected URL %s", entry.Request.URL)
+	}
+	if diff := cmp.Diff(entry.TLS, &har.TLSInfo{
+		ServerName:         "example.test",
+		Version:            "TLS 1.2",
+		CipherSuite:        "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		ALPN:               "h2",
+		CertificateSubject: "CN=example.test",
+		CertificateExpiry:  &expiry,
+	}); diff != "" {
+		t.Errorf("TLS info doesn't match (-got +expected):\n%s\n", diff)
+	}
+	if diff := cmp.Diff(entry.Timings, har.EntryTimings{
+		Blocked: -1, BlockedQueueing: -1, DNS: -1,
+		Connect: 10, SSL: 7, Send: 2, Wait: 8, Receive: 5,
+	}); diff != "" {
+		t.Errorf("Timings don't match (-got +expected):\n%s\n", diff)
+	}
+	if !entry.StartedDateTime.Equal(at(0)) {
+		t.Errorf("Expected the entry to start with the SYN, got %s", entry.StartedDateTime)
+	}
+}
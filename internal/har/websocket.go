@@ -0,0 +1,54 @@
+package har
+
+import (
+	"encoding/base64"
+	"sort"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// WebSocketMessage is a message sent over a WebSocket, in the form Chrome
+// exports them.
+type WebSocketMessage struct {
+	// Type is send or receive.
+	Type string `json:"type"`
+	// Time in seconds since the epoch.
+	Time   float64 `json:"time"`
+	Opcode int     `json:"opcode"`
+	// Data is the text of the message, base64 encoded for binary messages.
+	Data string `json:"data"`
+}
+
+// opcode for binary messages.
+const opBinary = 2
+
+func extractWebSocketMessages(messages []reader.WebSocketMessage) []WebSocketMessage {
+	if len(messages) == 0 {
+		return nil
+	}
+	// the two directions are read independently so they need to be put
+	// back in order.
+	sorted := make([]reader.WebSocketMessage, len(messages))
+	copy(sorted, messages)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	wsMessages := make([]WebSocketMessage, len(sorted))
+	for i, m := range sorted {
+		msg := WebSocketMessage{
+			Type:   "receive",
+			Time:   float64(m.Time.UnixNano()) / 1e9,
+			Opcode: m.Opcode,
+			Data:   string(m.Data),
+		}
+		if m.Sent {
+			msg.Type = "send"
+		}
+		if m.Opcode == opBinary {
+			msg.Data = base64.StdEncoding.EncodeToString(m.Data)
+		}
+		wsMessages[i] = msg
+	}
+	return wsMessages
+}
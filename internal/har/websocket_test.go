@@ -0,0 +1,46 @@
+package har_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestHarWebSocketMessages(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	var h har.Har
+	h.AddEntry(reader.Conversation{
+		Address: reader.ConversationAddress{IP: gopacket.NewFlow(1,
+			[]byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f, 0x0, 0x0, 0x1}), Port: gopacket.NewFlow(4,
+			[]byte{0x23, 0x36}, []byte{0x1f, 0x90})},
+		Request: &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/chat"},
+			Host:   "localhost:8080",
+			Proto:  "HTTP/1.1",
+			Header: http.Header{"Upgrade": {"websocket"}},
+		},
+		RequestSeen: []time.Time{start},
+		// each side is read separately so they're not in order.
+		WebSocketMessages: []reader.WebSocketMessage{
+			{Sent: true, Time: start.Add(time.Second), Opcode: 1, Data: []byte("hello")},
+			{Sent: true, Time: start.Add(3 * time.Second), Opcode: 1, Data: []byte("bye")},
+			{Time: start.Add(2 * time.Second), Opcode: 2, Data: []byte{0, 1, 2}},
+		},
+	})
+
+	expected := []har.WebSocketMessage{
+		{Type: "send", Time: 1600000001, Opcode: 1, Data: "hello"},
+		{Type: "receive", Time: 1600000002, Opcode: 2, Data: "AAEC"},
+		{Type: "send", Time: 1600000003, Opcode: 1, Data: "bye"},
+	}
+	if diff := cmp.Diff(h.Log.Entries[0].WebSocketMessages, expected); diff != "" {
+		t.Errorf("Messages don't match (-got +expected):\n%s\n", diff)
+	}
+}
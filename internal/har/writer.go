@@ -0,0 +1,343 @@
+package har
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+)
+
+// DefaultSortRunSize is the number of entries sorted in memory at a time
+// when WriterOptions.SortRunSize isn't set.
+const DefaultSortRunSize = 1000
+
+// the indentation of the entries and pages within the document.
+const itemIndent = "      "
+
+// WriterOptions controls how the Writer builds and writes the entries.
+type WriterOptions struct {
+	Options
+	// Sort writes the entries in the order they were started rather than
+	// the order they were added.  The entries are sorted in runs of
+	// SortRunSize that are kept in temporary files in TempDir, then merged,
+	// so that they don't all need to be held in memory.
+	Sort        bool
+	SortRunSize int
+	// TempDir is where the temporary files go, the default temporary
+	// directory when it's empty.
+	TempDir string
+}
+
+// Writer writes a HAR document as the conversations are added to it, rather
+// than holding on to all the entries like Har does.  The pages are written
+// after the entries, and are kept in a temporary file until then.
+type Writer struct {
+	w       *bufio.Writer
+	creator Creator
+	options WriterOptions
+	h       Har
+	json    jsoniter.API
+	started bool
+	entries int
+	pages   *os.File
+	// run has the entries waiting to be sorted, and runs the temporary
+	// files holding the sorted runs.
+	run  []sortRecord
+	runs []*os.File
+}
+
+// sortRecord is an entry waiting to be sorted, along with the details needed
+// for its page.
+type sortRecord struct {
+	Started time.Time       `json:"started"`
+	URL     string          `json:"url"`
+	Entry   json.RawMessage `json:"entry"`
+}
+
+// NewWriter returns a Writer writing the HAR document to w.  Close needs to
+// be called to finish the document.
+func NewWriter(w io.Writer, creator Creator, options WriterOptions) *Writer {
+	if options.SortRunSize <= 0 {
+		options.SortRunSize = DefaultSortRunSize
+	}
+	return &Writer{
+		w:       bufio.NewWriter(w),
+		creator: creator,
+		options: options,
+		h:       Har{Options: options.Options},
+		json:    jsoniter.ConfigCompatibleWithStandardLibrary,
+	}
+}
+
+// Add turns the conversation into an entry and writes it, or holds on to it
+// until it can be sorted.
+func (w *Writer) Add(v reader.Conversation) error {
+	// the bodies spilled to disk are only needed while the entry is made.
+	defer v.RemoveBodyFiles()
+	entry, ok := w.h.entry(v)
+	if !ok {
+		return nil
+	}
+	data, err := w.json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	record := sortRecord{Started: entry.StartedDateTime, URL: entry.Request.URL, Entry: data}
+	if !w.options.Sort {
+		return w.write(record)
+	}
+	w.run = append(w.run, record)
+	if len(w.run) >= w.options.SortRunSize {
+		return w.spill()
+	}
+	return nil
+}
+
+// Close writes out any entries still waiting to be sorted along with the
+// pages, and finishes off the document.  It doesn't close the underlying
+// writer.
+func (w *Writer) Close() error {
+	defer w.cleanup()
+	if err := w.writeSorted(); err != nil {
+		return err
+	}
+	if err := w.start(); err != nil {
+		return err
+	}
+	if w.entries > 0 {
+		w.w.WriteString("\n    ")
+	}
+	w.w.WriteString("],\n    \"pages\": [")
+	if w.pages != nil {
+		if _, err := w.pages.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w.w, w.pages); err != nil {
+			return err
+		}
+		w.w.WriteString("\n    ")
+	}
+	w.w.WriteString("]\n  }\n}\n")
+	return w.w.Flush()
+}
+
+// start writes the start of the document, up to the entries.
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	creator, err := w.indent(w.creator, "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w.w, "{\n  \"log\": {\n    \"version\": \"1.2\",\n    \"creator\": %s,\n    \"entries\": [", creator)
+	return err
+}
+
+// write writes the entry, and adds its page to the temporary file for the
+// pages.  The pages are numbered in the order the entries are written.
+func (w *Writer) write(record sortRecord) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	var entry bytes.Buffer
+	if err := json.Indent(&entry, record.Entry, itemIndent, "  "); err != nil {
+		return err
+	}
+	if w.entries > 0 {
+		w.w.WriteString(",")
+	}
+	w.w.WriteString("\n" + itemIndent)
+	if _, err := entry.WriteTo(w.w); err != nil {
+		return err
+	}
+	w.entries++
+
+	page, err := w.indent(Page{
+		ID:              fmt.Sprintf("page_%d", w.entries),
+		Title:           record.URL,
+		StartedDateTime: record.Started,
+		PageTimings:     PageTiming{-1, -1},
+	}, itemIndent)
+	if err != nil {
+		return err
+	}
+	if w.pages == nil {
+		if w.pages, err = os.CreateTemp(w.options.TempDir, "pcap2har-pages-"); err != nil {
+			return err
+		}
+	}
+	if w.entries > 1 {
+		page = append([]byte(","), page...)
+	}
+	_, err = w.pages.Write(append(append([]byte("\n"), itemIndent...), page...))
+	return err
+}
+
+// indent marshals v indented to sit at prefix within the document.
+func (w *Writer) indent(v interface{}, prefix string) ([]byte, error) {
+	data, err := w.json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, data, prefix, "  "); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// sortRun sorts the run by the time the entries were started, keeping the
+// order they were added when they were started at the same time.
+func (w *Writer) sortRun() {
+	sort.SliceStable(w.run, func(i, j int) bool {
+		return w.run[i].Started.Before(w.run[j].Started)
+	})
+}
+
+// spill sorts the current run and writes it to a temporary file, one record
+// per line.
+func (w *Writer) spill() error {
+	w.sortRun()
+	f, err := os.CreateTemp(w.options.TempDir, "pcap2har-sort-")
+	if err != nil {
+		return err
+	}
+	w.runs = append(w.runs, f)
+	out := bufio.NewWriter(f)
+	for _, record := range w.run {
+		data, err := w.json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		out.Write(data)
+		out.WriteByte('\n')
+	}
+	w.run = w.run[:0]
+	return out.Flush()
+}
+
+// writeSorted writes out the entries waiting to be sorted, merging the runs
+// in the temporary files when there are any.
+func (w *Writer) writeSorted() error {
+	if len(w.runs) == 0 {
+		w.sortRun()
+		for _, record := range w.run {
+			if err := w.write(record); err != nil {
+				return err
+			}
+		}
+		w.run = nil
+		return nil
+	}
+	if len(w.run) > 0 {
+		if err := w.spill(); err != nil {
+			return err
+		}
+	}
+	var runs mergeRuns
+	for i, f := range w.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r := &mergeRun{index: i, r: bufio.NewReader(f), json: w.json}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			runs = append(runs, r)
+		}
+	}
+	heap.Init(&runs)
+	for len(runs) > 0 {
+		r := runs[0]
+		if err := w.write(r.record); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&runs, 0)
+		} else {
+			heap.Pop(&runs)
+		}
+	}
+	return nil
+}
+
+// cleanup removes the temporary files.
+func (w *Writer) cleanup() {
+	files := w.runs
+	if w.pages != nil {
+		files = append(files, w.pages)
+	}
+	for _, f := range files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	w.runs = nil
+	w.pages = nil
+}
+
+// mergeRun reads back a sorted run from its temporary file.
+type mergeRun struct {
+	index  int
+	r      *bufio.Reader
+	json   jsoniter.API
+	record sortRecord
+}
+
+// next reads the next record, returning false at the end of the run.
+func (r *mergeRun) next() (bool, error) {
+	line, err := r.r.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return false, nil
+	}
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	r.record = sortRecord{}
+	if err := r.json.Unmarshal(line, &r.record); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// mergeRuns is a heap of the runs ordered by their next record.  Records
+// started at the same time come from the earliest run first so the sort is
+// stable.
+type mergeRuns []*mergeRun
+
+func (m mergeRuns) Len() int { return len(m) }
+
+func (m mergeRuns) Less(i, j int) bool {
+	a, b := m[i].record.Started, m[j].record.Started
+	if a.Equal(b) {
+		return m[i].index < m[j].index
+	}
+	return a.Before(b)
+}
+
+func (m mergeRuns) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+
+func (m *mergeRuns) Push(x interface{}) { *m = append(*m, x.(*mergeRun)) }
+
+func (m *mergeRuns) Pop() interface{} {
+	old := *m
+	r := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return r
+}
@@ -0,0 +1,119 @@
+package har_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/har"
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func writerConversations(t *testing.T) []reader.Conversation {
+	t.Helper()
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	var conversations []reader.Conversation
+	// the last two start at the same time so should stay in this order.
+	for i, ms := range []int{40, 10, 30, 0, 20, 20} {
+		u, err := url.Parse("/" + string(rune('a'+i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := start.Add(time.Duration(ms) * time.Millisecond)
+		conversations = append(conversations, reader.Conversation{
+			Address: reader.ConversationAddress{
+				IP:   gopacket.NewFlow(1, []byte{127, 0, 0, 1}, []byte{127, 0, 0, 1}),
+				Port: gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90}),
+			},
+			Request: &http.Request{
+				Method: "GET", URL: u, Host: "localhost:8080", Proto: "HTTP/1.1",
+				ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{},
+			},
+			RequestSeen: []time.Time{seen},
+		})
+	}
+	return conversations
+}
+
+func writeHar(t *testing.T, conversations []reader.Conversation, options har.WriterOptions) interface{} {
+	t.Helper()
+	var b bytes.Buffer
+	w := har.NewWriter(&b, har.Creator{Name: "pcap2har", Version: "test"}, options)
+	for _, c := range conversations {
+		if err := w.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid JSON %s\n%s", err, b.String())
+	}
+	return doc
+}
+
+func TestWriterSort(t *testing.T) {
+	conversations := writerConversations(t)
+
+	var h har.Har
+	h.Log.Version = "1.2"
+	h.Log.Creator = har.Creator{Name: "pcap2har", Version: "test"}
+	for _, c := range writerConversations(t) {
+		h.AddEntry(c)
+	}
+	h.FinaliseAndSort()
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected interface{}
+	if err := json.Unmarshal(data, &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, 2, 4} {
+		got := writeHar(t, conversations, har.WriterOptions{
+			Sort: true, SortRunSize: size, TempDir: t.TempDir(),
+		})
+		if diff := cmp.Diff(got, expected); diff != "" {
+			t.Errorf("Sorted in runs of %d don't match (-got +expected):\n%s\n", size, diff)
+		}
+	}
+}
+
+func TestWriterUnsorted(t *testing.T) {
+	doc := writeHar(t, writerConversations(t), har.WriterOptions{TempDir: t.TempDir()})
+	log := doc.(map[string]interface{})["log"].(map[string]interface{})
+	var urls []string
+	for _, e := range log["entries"].([]interface{}) {
+		urls = append(urls, e.(map[string]interface{})["request"].(map[string]interface{})["url"].(string))
+	}
+	expected := []string{
+		"http://localhost:8080/a", "http://localhost:8080/b", "http://localhost:8080/c",
+		"http://localhost:8080/d", "http://localhost:8080/e", "http://localhost:8080/f",
+	}
+	if diff := cmp.Diff(urls, expected); diff != "" {
+		t.Errorf("Entries not in the order added (-got +expected):\n%s\n", diff)
+	}
+	pages := log["pages"].([]interface{})
+	if len(pages) != 6 || pages[5].(map[string]interface{})["id"] != "page_6" {
+		t.Errorf("Unexpected pages %v", pages)
+	}
+
+	empty := writeHar(t, nil, har.WriterOptions{})
+	if diff := cmp.Diff(empty, map[string]interface{}{"log": map[string]interface{}{
+		"version": "1.2",
+		"creator": map[string]interface{}{"name": "pcap2har", "version": "test"},
+		"entries": []interface{}{},
+		"pages":   []interface{}{},
+	}}); diff != "" {
+		t.Errorf("Empty document doesn't match (-got +expected):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,112 @@
+// Package pcapng reads the parts of pcapng files that the packet readers skip
+// over.
+//
+// See https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html for
+// the file format.
+package pcapng
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	blockTypeSectionHeader     = 0x0A0D0D0A
+	blockTypeDecryptionSecrets = 0x0000000A
+	byteOrderMagic             = 0x1A2B3C4D
+	// secrets type for TLS key log lines in a Decryption Secrets Block.
+	secretsTypeTLSKeyLog = 0x544c534b
+)
+
+// ErrNotPcapng is returned when the file doesn't start with a pcapng section
+// header.
+var ErrNotPcapng = errors.New("pcapng: not a pcapng file")
+
+// TLSKeyLogs returns the contents of the TLS key log Decryption Secrets Blocks
+// in the file.
+func TLSKeyLogs(r io.Reader) ([][]byte, error) {
+	var keyLogs [][]byte
+	var order binary.ByteOrder = binary.LittleEndian
+	first := true
+	for {
+		var h [8]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			if err == io.EOF && !first {
+				return keyLogs, nil
+			}
+			if first {
+				return nil, ErrNotPcapng
+			}
+			return keyLogs, err
+		}
+
+		blockType := binary.LittleEndian.Uint32(h[:4])
+		if blockType == blockTypeSectionHeader {
+			// each section can have a different byte order.
+			var magic [4]byte
+			if _, err := io.ReadFull(r, magic[:]); err != nil {
+				return keyLogs, err
+			}
+			switch {
+			case binary.LittleEndian.Uint32(magic[:]) == byteOrderMagic:
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(magic[:]) == byteOrderMagic:
+				order = binary.BigEndian
+			default:
+				return keyLogs, fmt.Errorf("pcapng: invalid byte order magic %x", magic)
+			}
+			if err := skip(r, int64(order.Uint32(h[4:]))-12); err != nil {
+				return keyLogs, err
+			}
+			first = false
+			continue
+		}
+		if first {
+			return nil, ErrNotPcapng
+		}
+
+		length := int64(order.Uint32(h[4:]))
+		if length < 12 || length%4 != 0 {
+			return keyLogs, fmt.Errorf("pcapng: invalid block length %d", length)
+		}
+		if order.Uint32(h[:4]) != blockTypeDecryptionSecrets {
+			if err := skip(r, length-8); err != nil {
+				return keyLogs, err
+			}
+			continue
+		}
+
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return keyLogs, err
+		}
+		if secretsType, secrets, ok := decryptionSecrets(order, body); ok &&
+			secretsType == secretsTypeTLSKeyLog {
+			keyLogs = append(keyLogs, secrets)
+		}
+	}
+}
+
+// decryptionSecrets pulls the secrets out of the body of a Decryption Secrets
+// Block.
+func decryptionSecrets(order binary.ByteOrder, body []byte) (uint32, []byte, bool) {
+	if len(body) < 8 {
+		return 0, nil, false
+	}
+	secretsType := order.Uint32(body)
+	length := order.Uint32(body[4:])
+	if uint64(length) > uint64(len(body)-8) {
+		return 0, nil, false
+	}
+	return secretsType, body[8 : 8+length], true
+}
+
+func skip(r io.Reader, n int64) error {
+	if n < 0 {
+		return errors.New("pcapng: invalid block length")
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
@@ -0,0 +1,72 @@
+package pcapng_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/colinnewell/pcap2har-go/internal/pcapng"
+	"github.com/google/go-cmp/cmp"
+)
+
+func block(order binary.AppendByteOrder, blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	length := uint32(len(body) + 12)
+	b := order.AppendUint32(nil, blockType)
+	b = order.AppendUint32(b, length)
+	b = append(b, body...)
+	return order.AppendUint32(b, length)
+}
+
+func sectionHeader(order binary.AppendByteOrder) []byte {
+	body := order.AppendUint32(nil, 0x1A2B3C4D)
+	body = order.AppendUint16(body, 1)
+	body = order.AppendUint16(body, 0)
+	// section length unknown.
+	body = append(body, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	return block(order, 0x0A0D0D0A, body)
+}
+
+func secrets(order binary.AppendByteOrder, secretsType uint32, data string) []byte {
+	body := order.AppendUint32(nil, secretsType)
+	body = order.AppendUint32(body, uint32(len(data)))
+	body = append(body, data...)
+	return block(order, 0x0000000A, body)
+}
+
+func TestTLSKeyLogs(t *testing.T) {
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var file []byte
+		file = append(file, sectionHeader(order)...)
+		// interface description block.
+		file = append(file, block(order, 1, []byte{1, 0, 0, 0, 0, 0, 0, 0})...)
+		file = append(file, secrets(order, 0x544c534b, "CLIENT_RANDOM 01 02\n")...)
+		// some other type of secret.
+		file = append(file, secrets(order, 0x57474b4c, "wireguard")...)
+		file = append(file, secrets(order, 0x544c534b, "CLIENT_RANDOM 03 04\n")...)
+
+		keyLogs, err := pcapng.TLSKeyLogs(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, k := range keyLogs {
+			got = append(got, string(k))
+		}
+		expected := []string{"CLIENT_RANDOM 01 02\n", "CLIENT_RANDOM 03 04\n"}
+		if diff := cmp.Diff(got, expected); diff != "" {
+			t.Errorf("Key logs don't match for %s (-got +expected):\n%s\n", order, diff)
+		}
+	}
+}
+
+func TestTLSKeyLogsNotPcapng(t *testing.T) {
+	// classic pcap header.
+	file := []byte{0xd4, 0xc3, 0xb2, 0xa1, 2, 0, 4, 0, 0, 0, 0, 0}
+	if _, err := pcapng.TLSKeyLogs(bytes.NewReader(file)); !errors.Is(err, pcapng.ErrNotPcapng) {
+		t.Errorf("Expected ErrNotPcapng, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package reader
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// BackendCall is a FastCGI request the web server made while handling an
+// HTTP request, so that the time spent in the application can be told apart
+// from the time spent in the server in front of it.
+type BackendCall struct {
+	// Address of the FastCGI connection.
+	Address      ConversationAddress
+	Method       string
+	RequestURI   string
+	RequestSeen  []time.Time
+	ResponseSeen []time.Time
+	// Status of the response, 0 when there wasn't one.
+	Status int
+	// Errors the application wrote to stderr.
+	Errors []FCGIError
+	// AppStatus and ProtocolStatus are what the application sent at the
+	// end of the request, and Aborted is set when the web server aborted
+	// it.
+	AppStatus      *int
+	ProtocolStatus string
+	Aborted        bool
+}
+
+// requestIDHeaders are the headers used to identify a request as it's passed
+// from one server to the next.
+var requestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id"}
+
+// backendKey is where a FastCGI conversation is in h.conversations.
+type backendKey struct {
+	address ConversationAddress
+	n       int
+}
+
+// backendCall is a FastCGI call waiting to be matched to the HTTP request
+// it was made for.
+type backendCall struct {
+	BackendCall
+	requestID string
+	// start and end are when the call was made and when its response
+	// finished.
+	start, end time.Time
+	// key is set for calls whose connection hasn't been taken yet.
+	key     *backendKey
+	matched bool
+}
+
+// fastCGIRequest marks the nth conversation from the client at address as
+// a FastCGI request with the params and headers given.  It's noted under its
+// request ID so that the response can find it, and so that it can be matched
+// to the HTTP request it was made for.
+func (h *HTTPConversationReaders) fastCGIRequest(address ConversationAddress, n int, id uint16, params map[string]string, headers []HeaderField) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.conversation(address, n)
+	c.FastCGI = true
+	c.FCGIParams = params
+	c.RequestHeaders = headers
+	h.backendPending[backendKey{address, n}] = false
+	if h.fcgiIDs[address] == nil {
+		h.fcgiIDs[address] = make(map[uint16][]int)
+	}
+	h.fcgiIDs[address][id] = append(h.fcgiIDs[address][id], n)
+	h.cond.Broadcast()
+}
+
+// newBackendCall summarises the FastCGI conversation.
+func newBackendCall(c *Conversation) backendCall {
+	call := backendCall{
+		BackendCall: BackendCall{
+			Address:        c.Address,
+			Method:         c.Request.Method,
+			RequestURI:     requestURI(c.Request),
+			RequestSeen:    c.RequestSeen,
+			ResponseSeen:   c.ResponseSeen,
+			Errors:         append([]FCGIError(nil), c.FCGIErrors...),
+			AppStatus:      c.FCGIAppStatus,
+			ProtocolStatus: c.FCGIProtocolStatus,
+			Aborted:        c.FCGIAborted,
+		},
+		requestID: requestID(c.Request.Header),
+	}
+	if c.Response != nil {
+		call.Status = c.Response.StatusCode
+	}
+	if len(c.RequestSeen) > 0 {
+		call.start = c.RequestSeen[0]
+	}
+	call.end = call.start
+	if len(c.ResponseSeen) > 0 {
+		call.end = c.ResponseSeen[len(c.ResponseSeen)-1]
+	}
+	return call
+}
+
+// keepBackendCalls holds on to the FastCGI calls on a connection being
+// taken that haven't been matched yet, as the HTTP requests they were made
+// for may still be in progress.  h.mu must be held.
+func (h *HTTPConversationReaders) keepBackendCalls(address ConversationAddress, conversations []Conversation) {
+	for n := range conversations {
+		c := &conversations[n]
+		key := backendKey{address, n}
+		matched, ok := h.backendPending[key]
+		if !ok {
+			continue
+		}
+		delete(h.backendPending, key)
+		if !matched && c.Request != nil {
+			h.backendCalls = append(h.backendCalls, newBackendCall(c))
+		}
+	}
+}
+
+// dropBackendCalls lets go of the kept FastCGI calls that can't be matched
+// any more because they'd finished before any of the HTTP requests still
+// open were started.  h.mu must be held.
+func (h *HTTPConversationReaders) dropBackendCalls() {
+	if len(h.backendCalls) == 0 {
+		return
+	}
+	var oldest time.Time
+	for _, conversations := range h.conversations {
+		for _, c := range conversations {
+			if c.FastCGI || len(c.RequestSeen) == 0 {
+				continue
+			}
+			if oldest.IsZero() || c.RequestSeen[0].Before(oldest) {
+				oldest = c.RequestSeen[0]
+			}
+		}
+	}
+	kept := h.backendCalls[:0]
+	for _, call := range h.backendCalls {
+		if !oldest.IsZero() && !oldest.After(call.end) {
+			kept = append(kept, call)
+		}
+	}
+	clear(h.backendCalls[len(kept):])
+	h.backendCalls = kept
+}
+
+// matchBackendCalls adds the FastCGI calls made while handling each of the
+// HTTP requests.  A call is matched by the request ID headers when both
+// have one, otherwise by the method and URI.  Either way it has to have
+// been made after the request started and before the response finished.
+// h.mu must be held.
+func (h *HTTPConversationReaders) matchBackendCalls(conversations []Conversation) {
+	if len(h.backendPending) == 0 && len(h.backendCalls) == 0 {
+		return
+	}
+	candidates := h.backendCandidates()
+	for i := range conversations {
+		c := &conversations[i]
+		if c.Request == nil || c.FastCGI || len(c.RequestSeen) == 0 {
+			continue
+		}
+		for _, call := range matchingCalls(c, candidates) {
+			call.matched = true
+			c.BackendCalls = append(c.BackendCalls, call.BackendCall)
+			if call.key != nil {
+				h.backendPending[*call.key] = true
+			}
+		}
+	}
+	kept := h.backendCalls[:0]
+	for _, call := range h.backendCalls {
+		if !call.matched {
+			kept = append(kept, call)
+		}
+	}
+	h.backendCalls = kept
+}
+
+// backendCandidates lists the FastCGI calls that haven't been matched yet,
+// in the order they were made.  h.mu must be held.
+func (h *HTTPConversationReaders) backendCandidates() []*backendCall {
+	var candidates []*backendCall
+	for i := range h.backendCalls {
+		candidates = append(candidates, &h.backendCalls[i])
+	}
+	for key, matched := range h.backendPending {
+		c := h.conversation(key.address, key.n)
+		if matched || c.Request == nil {
+			continue
+		}
+		call := newBackendCall(c)
+		call.key = &key
+		candidates = append(candidates, &call)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].start.Before(candidates[j].start)
+	})
+	return candidates
+}
+
+// matchingCalls picks the calls made for the HTTP request.  Every call with
+// the same request ID is included, as the server may have retried, but
+// without one only the first call with the same method and URI is.
+func matchingCalls(c *Conversation, candidates []*backendCall) []*backendCall {
+	id := requestID(c.Request.Header)
+	uri := requestURI(c.Request)
+	start := c.RequestSeen[0]
+	var end time.Time
+	if len(c.ResponseSeen) > 0 {
+		end = c.ResponseSeen[len(c.ResponseSeen)-1]
+	}
+	var byID []*backendCall
+	var first *backendCall
+	for _, call := range candidates {
+		if call.matched || call.start.Before(start) || (!end.IsZero() && call.start.After(end)) {
+			continue
+		}
+		if id != "" && call.requestID != "" {
+			if id == call.requestID {
+				byID = append(byID, call)
+			}
+			continue
+		}
+		if first == nil && call.Method == c.Request.Method && call.RequestURI == uri {
+			first = call
+		}
+	}
+	if len(byID) == 0 && first != nil {
+		return []*backendCall{first}
+	}
+	return byID
+}
+
+func requestID(header http.Header) string {
+	for _, name := range requestIDHeaders {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// requestURI is the URI as it was sent, which FastCGI passes on as
+// REQUEST_URI.
+func requestURI(req *http.Request) string {
+	if req.RequestURI != "" {
+		return req.RequestURI
+	}
+	return req.URL.RequestURI()
+}
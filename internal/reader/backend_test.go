@@ -0,0 +1,247 @@
+package reader_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+// FastCGI record types.
+const (
+	fcgiBeginRequest = 1
+	fcgiAbortRequest = 2
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+)
+
+func fcgiRecord(recType byte, id uint16, content string) string {
+	header := []byte{1, recType, byte(id >> 8), byte(id), byte(len(content) >> 8), byte(len(content)), 0, 0}
+	return string(header) + content
+}
+
+// fcgiRequest is a request from the web server with the params given as
+// name, value pairs.
+func fcgiRequest(id uint16, params ...string) string {
+	return fcgiBegin(id) + fcgiParamsRecords(id, params...) + fcgiRecord(fcgiStdin, id, "")
+}
+
+func fcgiBegin(id uint16) string {
+	return fcgiRecord(fcgiBeginRequest, id, "\x00\x01\x00\x00\x00\x00\x00\x00")
+}
+
+func fcgiParamsRecords(id uint16, params ...string) string {
+	var encoded []byte
+	for i := 0; i+1 < len(params); i += 2 {
+		encoded = append(encoded, byte(len(params[i])), byte(len(params[i+1])))
+		encoded = append(encoded, params[i]+params[i+1]...)
+	}
+	return fcgiRecord(fcgiParams, id, string(encoded)) + fcgiRecord(fcgiParams, id, "")
+}
+
+// fcgiResponse is the application's response, with what it wrote to stderr
+// if anything.
+func fcgiResponse(id uint16, stderr, stdout string) string {
+	var response string
+	if stderr != "" {
+		response += fcgiRecord(fcgiStderr, id, stderr)
+	}
+	return response + fcgiRecord(fcgiStdout, id, stdout) +
+		fcgiRecord(fcgiStdout, id, "") +
+		fcgiEnd(id, 0, 0)
+}
+
+func fcgiEnd(id uint16, appStatus uint32, protocolStatus byte) string {
+	return fcgiRecord(fcgiEndRequest, id, string([]byte{
+		byte(appStatus >> 24), byte(appStatus >> 16), byte(appStatus >> 8), byte(appStatus),
+		protocolStatus, 0, 0, 0,
+	}))
+}
+
+func TestBackendCalls(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	clientIP := gopacket.NewFlow(1, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	backendIP := gopacket.NewFlow(1, []byte{10, 0, 0, 2}, []byte{10, 0, 0, 3})
+	port := func(client byte, server uint16) gopacket.Flow {
+		return gopacket.NewFlow(4, []byte{0xc3, client}, []byte{byte(server >> 8), byte(server)})
+	}
+
+	r := reader.New()
+	read := func(ip, port gopacket.Flow, request string, requestSeen int, response string, responseSeen int) {
+		r.ReadStream(newTimedReader(request, at(requestSeen)), ip, port, nil)
+		r.ReadStream(newTimedReader(response, at(responseSeen)), ip.Reverse(), port.Reverse(), nil)
+	}
+	// nginx rewrote the URI of the first, but passed on its request ID.
+	read(clientIP, port(1, 80), "GET /a HTTP/1.1\r\nHost: example.com\r\nX-Request-Id: abc\r\n\r\n", 0,
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", 10)
+	read(clientIP, port(2, 80), "POST /b?c=d HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\n\r\n", 20,
+		"HTTP/1.1 500 Internal Server Error\r\nContent-Length: 0\r\n\r\n", 40)
+	read(backendIP, port(3, 9000), fcgiRequest(1,
+		"REQUEST_METHOD", "GET", "REQUEST_URI", "/index.php", "SERVER_PROTOCOL", "HTTP/1.1",
+		"HTTP_HOST", "example.com", "HTTP_X_REQUEST_ID", "abc",
+	), 1, fcgiResponse(1, "", "Content-Type: text/plain\r\n\r\nok"), 8)
+	read(backendIP, port(4, 9000), fcgiRequest(1,
+		"REQUEST_METHOD", "POST", "REQUEST_URI", "/b?c=d", "SERVER_PROTOCOL", "HTTP/1.1",
+		"HTTP_HOST", "example.com",
+	), 21, fcgiResponse(1, "PHP Fatal error: oops", "Status: 500 Internal Server Error\r\n\r\n"), 35)
+	// the same request made again after the response doesn't match.
+	read(backendIP, port(5, 9000), fcgiRequest(1,
+		"REQUEST_METHOD", "POST", "REQUEST_URI", "/b?c=d", "SERVER_PROTOCOL", "HTTP/1.1",
+		"HTTP_HOST", "example.com",
+	), 50, fcgiResponse(1, "", "\r\n"), 51)
+
+	calls := map[string][]reader.BackendCall{}
+	fastCGI := 0
+	exitStatus := 0
+	for _, c := range r.GetConversations() {
+		if c.FastCGI {
+			fastCGI++
+			continue
+		}
+		calls[c.Request.RequestURI] = c.BackendCalls
+	}
+	if fastCGI != 3 {
+		t.Errorf("Expected 3 FastCGI conversations, got %d", fastCGI)
+	}
+	expected := map[string][]reader.BackendCall{
+		"/a": {{
+			Address:        reader.ConversationAddress{IP: backendIP, Port: port(3, 9000)},
+			Method:         "GET",
+			RequestURI:     "/index.php",
+			RequestSeen:    []time.Time{at(1)},
+			ResponseSeen:   []time.Time{at(8)},
+			Status:         200,
+			AppStatus:      &exitStatus,
+			ProtocolStatus: "REQUEST_COMPLETE",
+		}},
+		"/b?c=d": {{
+			Address:        reader.ConversationAddress{IP: backendIP, Port: port(4, 9000)},
+			Method:         "POST",
+			RequestURI:     "/b?c=d",
+			RequestSeen:    []time.Time{at(21)},
+			ResponseSeen:   []time.Time{at(35)},
+			Status:         500,
+			Errors:         []reader.FCGIError{{Message: "PHP Fatal error: oops", Seen: at(35)}},
+			AppStatus:      &exitStatus,
+			ProtocolStatus: "REQUEST_COMPLETE",
+		}},
+	}
+	if diff := cmp.Diff(calls, expected, cmp.Comparer(flowCompare)); diff != "" {
+		t.Errorf("Backend calls don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestBackendCallsKeepAlive(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	clientIP := gopacket.NewFlow(1, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	backendIP := gopacket.NewFlow(1, []byte{10, 0, 0, 2}, []byte{10, 0, 0, 3})
+	port := func(client byte, server uint16) gopacket.Flow {
+		return gopacket.NewFlow(4, []byte{0xc3, client}, []byte{byte(server >> 8), byte(server)})
+	}
+	params := func(uri string) []string {
+		return []string{"REQUEST_METHOD", "GET", "REQUEST_URI", uri, "SERVER_PROTOCOL", "HTTP/1.1", "HTTP_HOST", "example.com"}
+	}
+
+	r := reader.New()
+	read := func(ip, port gopacket.Flow, request string, requestSeen int, response string, responseSeen int) {
+		r.ReadStream(newTimedReader(request, at(requestSeen)), ip, port, nil)
+		r.ReadStream(newTimedReader(response, at(responseSeen)), ip.Reverse(), port.Reverse(), nil)
+	}
+	read(clientIP, port(1, 80), "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n", 0,
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\na", 10)
+	read(clientIP, port(2, 80), "GET /b HTTP/1.1\r\nHost: example.com\r\n\r\n", 20,
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\nb", 40)
+	// nginx reuses the connection to php-fpm for the second request.
+	r.ReadStream(&timedReader{
+		packets: []string{fcgiRequest(1, params("/a")...), fcgiBegin(1), fcgiParamsRecords(1, params("/b")...),
+			fcgiRecord(fcgiStdin, 1, "")},
+		times: []time.Time{at(1), at(21), at(22), at(23)},
+	}, backendIP, port(3, 9000), nil)
+	r.ReadStream(&timedReader{
+		packets: []string{fcgiResponse(1, "", "\r\na"), fcgiRecord(fcgiStdout, 1, "\r\nb"),
+			fcgiRecord(fcgiStdout, 1, "") + fcgiEnd(1, 0, 0)},
+		times: []time.Time{at(8), at(30), at(35)},
+	}, backendIP.Reverse(), port(3, 9000).Reverse(), nil)
+
+	type seen struct {
+		Request, Response []time.Time
+	}
+	calls := map[string][]string{}
+	backendSeen := map[string]seen{}
+	for _, c := range r.GetConversations() {
+		if c.FastCGI {
+			backendSeen[c.Request.URL.Path] = seen{c.RequestSeen, c.ResponseSeen}
+			continue
+		}
+		for _, call := range c.BackendCalls {
+			calls[c.Request.RequestURI] = append(calls[c.Request.RequestURI], call.RequestURI)
+		}
+	}
+	expected := map[string][]string{"/a": {"/a"}, "/b": {"/b"}}
+	if diff := cmp.Diff(calls, expected); diff != "" {
+		t.Errorf("Backend calls don't match (-got +expected):\n%s\n", diff)
+	}
+	// the timings of the second request count from its own packets.
+	expectedSeen := map[string]seen{
+		"/a": {[]time.Time{at(1)}, []time.Time{at(8)}},
+		"/b": {[]time.Time{at(21), at(22), at(23)}, []time.Time{at(30), at(35)}},
+	}
+	if diff := cmp.Diff(backendSeen, expectedSeen); diff != "" {
+		t.Errorf("Backend times don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestBackendCallsStreamed(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	clientIP := gopacket.NewFlow(1, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	backendIP := gopacket.NewFlow(1, []byte{10, 0, 0, 2}, []byte{10, 0, 0, 3})
+	port := func(client byte, server uint16) gopacket.Flow {
+		return gopacket.NewFlow(4, []byte{0xc3, client}, []byte{byte(server >> 8), byte(server)})
+	}
+
+	r := reader.New()
+	conversations := r.StreamConversations()
+	next := func() reader.Conversation {
+		t.Helper()
+		select {
+		case c := <-conversations:
+			return c
+		case <-time.After(time.Second):
+			t.Fatal("Conversation wasn't sent once both sides were read")
+		}
+		return reader.Conversation{}
+	}
+
+	// the backend's connection is finished while the HTTP request is still
+	// waiting for its response, so its call is kept until then.
+	r.ReadStream(newTimedReader("GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n", at(0)),
+		clientIP, port(1, 80), nil)
+	r.ReadStream(newTimedReader(fcgiRequest(1, "REQUEST_METHOD", "GET", "REQUEST_URI", "/a",
+		"SERVER_PROTOCOL", "HTTP/1.1", "HTTP_HOST", "example.com"), at(1)),
+		backendIP, port(2, 9000), nil)
+	r.ReadStream(newTimedReader(fcgiResponse(1, "", "\r\nok"), at(5)),
+		backendIP.Reverse(), port(2, 9000).Reverse(), nil)
+	if c := next(); !c.FastCGI {
+		t.Fatalf("Expected the FastCGI conversation first, got %#v", c)
+	}
+	r.ReadStream(newTimedReader("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", at(10)),
+		clientIP.Reverse(), port(1, 80).Reverse(), nil)
+	c := next()
+	if len(c.BackendCalls) != 1 || c.BackendCalls[0].RequestURI != "/a" {
+		t.Errorf("Expected the kept call to be matched, got %#v", c.BackendCalls)
+	}
+}
@@ -0,0 +1,232 @@
+package reader
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/colinnewell/pcap2har-go/internal/contentencoding"
+)
+
+// BodyFile is a body that was spilled to a temporary file to keep within
+// MaxMemory.
+type BodyFile struct {
+	// Name of the temporary file.
+	Name string
+	Size int64
+}
+
+// Open opens the file for reading.
+func (f *BodyFile) Open() (*os.File, error) {
+	return os.Open(f.Name)
+}
+
+// Remove removes the file once the body is no longer needed.
+func (f *BodyFile) Remove() error {
+	return os.Remove(f.Name)
+}
+
+// body is what's kept of a request or response body once it's been read
+// and decompressed.
+type body struct {
+	data        []byte
+	file        *BodyFile
+	truncated   bool
+	decodeError string
+	chunks      []Chunk
+}
+
+// bodyBuffer collects a body as it's read.  It's kept in memory while it
+// fits within MaxMemory and spilled to a temporary file once it doesn't,
+// with anything after the first MaxBodySize bytes dropped.
+type bodyBuffer struct {
+	h    *HTTPConversationReaders
+	data bytes.Buffer
+	file *os.File
+	// size is how much of the body has been kept, and written how much of
+	// it we were given.
+	size, written int64
+	truncated     bool
+}
+
+func (h *HTTPConversationReaders) newBodyBuffer() *bodyBuffer {
+	return &bodyBuffer{h: h}
+}
+
+// readBody reads the whole of r into a bodyBuffer.
+func (h *HTTPConversationReaders) readBody(r io.Reader) (*bodyBuffer, error) {
+	b := h.newBodyBuffer()
+	_, err := io.Copy(b, r)
+	return b, err
+}
+
+// bufferBody puts a body that has already been read into a bodyBuffer so
+// that it's treated like the rest.
+func (h *HTTPConversationReaders) bufferBody(data []byte) *bodyBuffer {
+	b := h.newBodyBuffer()
+	_, _ = b.Write(data)
+	return b
+}
+
+func (b *bodyBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.written += int64(n)
+	if max := b.h.MaxBodySize; max > 0 && b.size+int64(len(p)) > max {
+		p = p[:max-b.size]
+		b.truncated = true
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	b.size += int64(len(p))
+	if b.file == nil && !b.h.reserve(int64(len(p))) && !b.spill() {
+		// go over the budget rather than lose the body.
+		b.h.memory.Add(int64(len(p)))
+	}
+	if b.file != nil {
+		if _, err := b.file.Write(p); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	b.data.Write(p)
+	return n, nil
+}
+
+// spill moves the body to a temporary file, giving back the memory it was
+// using.  It returns false if the file couldn't be written, in which case
+// the body stays in memory.
+func (b *bodyBuffer) spill() bool {
+	f, err := os.CreateTemp(b.h.TempDir, "pcap2har-body-")
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if _, err := f.Write(b.data.Bytes()); err != nil {
+		log.Println(err)
+		f.Close()
+		os.Remove(f.Name())
+		return false
+	}
+	b.h.release(int64(b.data.Len()))
+	b.data = bytes.Buffer{}
+	b.file = f
+	return true
+}
+
+// reader returns a reader for the body collected so far.
+func (b *bodyBuffer) reader() (io.ReadCloser, error) {
+	if b.file == nil {
+		return io.NopCloser(bytes.NewReader(b.data.Bytes())), nil
+	}
+	return os.Open(b.file.Name())
+}
+
+// discard throws the body away.
+func (b *bodyBuffer) discard() {
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+		b.file = nil
+	}
+	b.h.release(int64(b.data.Len()))
+	b.data = bytes.Buffer{}
+}
+
+// body returns the body collected for the conversation.  The memory it uses
+// stays reserved until the conversation is handed over.
+func (b *bodyBuffer) body() body {
+	if b.file == nil {
+		data := b.data.Bytes()
+		if data == nil {
+			data = []byte{}
+		}
+		return body{data: data, truncated: b.truncated}
+	}
+	b.file.Close()
+	return body{
+		file:      &BodyFile{Name: b.file.Name(), Size: b.size},
+		truncated: b.truncated,
+	}
+}
+
+// decodeBody undoes any content encodings applied to the body.  When that
+// isn't possible the body is left as it was with the reason why.
+func (h *HTTPConversationReaders) decodeBody(header http.Header, raw *bodyBuffer) body {
+	codings := contentencoding.Codings(header)
+	if len(codings) == 0 {
+		return raw.body()
+	}
+	failed := func(err error) body {
+		b := raw.body()
+		b.decodeError = err.Error()
+		return b
+	}
+	r, err := raw.reader()
+	if err != nil {
+		return failed(err)
+	}
+	defer r.Close()
+	decoder, err := contentencoding.NewReader(r, codings)
+	if err != nil {
+		return failed(err)
+	}
+	defer decoder.Close()
+	decoded := h.newBodyBuffer()
+	if _, err := io.Copy(decoded, decoder); err != nil {
+		decoded.discard()
+		return failed(err)
+	}
+	b := decoded.body()
+	b.truncated = b.truncated || raw.truncated
+	raw.discard()
+	return b
+}
+
+// reserve takes n bytes from the MaxMemory budget for a body, returning
+// false when there isn't enough left.
+func (h *HTTPConversationReaders) reserve(n int64) bool {
+	if h.memory.Add(n) > h.MaxMemory && h.MaxMemory > 0 {
+		h.memory.Add(-n)
+		return false
+	}
+	return true
+}
+
+// release gives back memory reserved for a body.
+func (h *HTTPConversationReaders) release(n int64) {
+	h.memory.Add(-n)
+}
+
+// releaseBodies gives back the memory used by the bodies of conversations
+// that are being handed over.
+func (h *HTTPConversationReaders) releaseBodies(conversations []Conversation) {
+	for _, c := range conversations {
+		h.release(int64(len(c.RequestBody) + len(c.ResponseBody)))
+	}
+}
+
+func (c *Conversation) setRequestBody(b body) {
+	c.RequestBody, c.RequestBodyFile = b.data, b.file
+	c.RequestTruncated, c.RequestDecodeError = b.truncated, b.decodeError
+	c.RequestChunks = b.chunks
+}
+
+func (c *Conversation) setResponseBody(b body) {
+	c.ResponseBody, c.ResponseBodyFile = b.data, b.file
+	c.ResponseTruncated, c.ResponseDecodeError = b.truncated, b.decodeError
+	c.ResponseChunks = b.chunks
+}
+
+// RemoveBodyFiles removes any temporary files holding the bodies.
+func (c *Conversation) RemoveBodyFiles() {
+	for _, f := range []*BodyFile{c.RequestBodyFile, c.ResponseBodyFile} {
+		if f != nil {
+			if err := f.Remove(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
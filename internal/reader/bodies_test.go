@@ -0,0 +1,97 @@
+package reader_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/gopacket"
+)
+
+func readBodies(t *testing.T, r *reader.HTTPConversationReaders, request, response string) reader.Conversation {
+	t.Helper()
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+	r.ReadStream(newReader([]string{request}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{response}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	return conversations[0]
+}
+
+func TestBodiesSpilled(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"plain": fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(content), content),
+		"gzip": fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s",
+			compressed.Len(), compressed.String()),
+	}
+	for name, response := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := reader.New()
+			r.MaxMemory = 1000
+			r.TempDir = t.TempDir()
+			c := readBodies(t, r, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nsmall", response)
+
+			if string(c.RequestBody) != "small" || c.RequestBodyFile != nil {
+				t.Errorf("Expected the small request body to be kept in memory, got %q %v",
+					c.RequestBody, c.RequestBodyFile)
+			}
+			if c.ResponseBody != nil || c.ResponseBodyFile == nil {
+				t.Fatalf("Expected the response body to be spilled, got %d bytes", len(c.ResponseBody))
+			}
+			if c.ResponseBodyFile.Size != int64(len(content)) {
+				t.Errorf("Expected the file to be %d bytes, got %d", len(content), c.ResponseBodyFile.Size)
+			}
+			written, err := os.ReadFile(c.ResponseBodyFile.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(written) != content || c.ResponseDecodeError != "" {
+				t.Errorf("Response body wasn't written: %d bytes %s", len(written), c.ResponseDecodeError)
+			}
+			c.RemoveBodyFiles()
+			files, err := os.ReadDir(r.TempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 0 {
+				t.Errorf("Expected the temporary files to have been removed, got %d", len(files))
+			}
+		})
+	}
+}
+
+func TestBodiesTruncated(t *testing.T) {
+	r := reader.New()
+	r.MaxBodySize = 4
+	c := readBodies(t, r,
+		"POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\nfour",
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n0123456789")
+
+	if string(c.RequestBody) != "four" || c.RequestTruncated {
+		t.Errorf("Expected the request to be kept whole, got %q truncated %t", c.RequestBody, c.RequestTruncated)
+	}
+	if string(c.ResponseBody) != "0123" || !c.ResponseTruncated {
+		t.Errorf("Expected the response to be truncated, got %q truncated %t", c.ResponseBody, c.ResponseTruncated)
+	}
+	if c.ResponseSize.Body != 10 {
+		t.Errorf("Expected the size of the body as sent, got %d", c.ResponseSize.Body)
+	}
+}
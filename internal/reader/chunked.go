@@ -0,0 +1,128 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colinnewell/pcap-cli/tcp"
+)
+
+var errMalformedChunk = errors.New("malformed chunked encoding")
+
+// Chunk is one of the chunks of a body sent with chunked transfer encoding.
+type Chunk struct {
+	// Size of the chunk's data, 0 for the last chunk.
+	Size int64
+	// Extensions are anything following the size on the chunk's line,
+	// without the leading semicolon.
+	Extensions string
+	// Seen is when the end of the chunk arrived.
+	Seen time.Time
+}
+
+// chunkedReader reads a body sent with chunked transfer encoding like the
+// reader in net/http does, but keeps track of the chunks so that we can show
+// the framing.  Once the body is read the trailers are in trailer.  When the
+// stream ends while reading the trailers we keep the ones we've got rather
+// than losing the body.
+type chunkedReader struct {
+	r         *bufio.Reader
+	t         *tcp.TimeCaptureReader
+	remaining int64
+	chunks    []Chunk
+	trailer   http.Header
+	err       error
+}
+
+// chunkedReader returns a chunkedReader to read the body with in place of
+// the one from net/http when it's chunked and RawFraming is set, or nil
+// otherwise.
+func (h *HTTPConversationReaders) chunkedReader(r *bufio.Reader, t *tcp.TimeCaptureReader, transferEncoding []string, body io.ReadCloser) *chunkedReader {
+	if !h.RawFraming || body == http.NoBody ||
+		len(transferEncoding) == 0 || transferEncoding[0] != "chunked" {
+		return nil
+	}
+	return &chunkedReader{r: r, t: t}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.err == nil {
+		if c.remaining == 0 {
+			c.err = c.beginChunk()
+			continue
+		}
+		if int64(len(p)) > c.remaining {
+			p = p[:c.remaining]
+		}
+		n, err := c.r.Read(p)
+		c.remaining -= int64(n)
+		if c.remaining == 0 && (err == nil || err == io.EOF) {
+			err = c.endChunk()
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, c.err
+}
+
+// beginChunk reads the line starting the next chunk, and the trailers if
+// it's the last one.
+func (c *chunkedReader) beginChunk() error {
+	line, err := c.r.ReadSlice('\n')
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return errMalformedChunk
+	}
+	line = bytes.TrimRight(line, " \t\r\n")
+	sizeText, extensions, _ := strings.Cut(string(line), ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+	if err != nil || size < 0 {
+		return errMalformedChunk
+	}
+	c.chunks = append(c.chunks, Chunk{Size: size, Extensions: strings.TrimSpace(extensions)})
+	if size > 0 {
+		c.remaining = size
+		return nil
+	}
+	c.chunks[len(c.chunks)-1].Seen = c.seen()
+	trailer, _ := textproto.NewReader(c.r).ReadMIMEHeader()
+	if len(trailer) > 0 {
+		c.trailer = http.Header(trailer)
+	}
+	return io.EOF
+}
+
+// endChunk reads the line ending the chunk's data.
+func (c *chunkedReader) endChunk() error {
+	end := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, end); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if string(end) != "\r\n" {
+		return errMalformedChunk
+	}
+	c.chunks[len(c.chunks)-1].Seen = c.seen()
+	return nil
+}
+
+// seen is when the last packet read arrived.
+func (c *chunkedReader) seen() time.Time {
+	if seen := c.t.Seen(); len(seen) > 0 {
+		return seen[len(seen)-1]
+	}
+	return time.Time{}
+}
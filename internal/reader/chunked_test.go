@@ -0,0 +1,93 @@
+package reader_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestRawFraming(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+	header := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n"
+
+	tests := []struct {
+		name     string
+		raw      bool
+		packets  []string
+		chunks   []reader.Chunk
+		trailers http.Header
+	}{
+		{
+			name:    "chunks",
+			raw:     true,
+			packets: []string{header + "4;part=1\r\nWiki\r\n", "5\r\npedia\r\n", "0\r\nX-Checksum: abc\r\n\r\n"},
+			chunks: []reader.Chunk{
+				{Size: 4, Extensions: "part=1", Seen: at(0)},
+				{Size: 5, Seen: at(10)},
+				{Size: 0, Seen: at(20)},
+			},
+			trailers: http.Header{"X-Checksum": {"abc"}},
+		},
+		{
+			name:    "truncated trailer",
+			raw:     true,
+			packets: []string{header + "4\r\nWiki\r\n5\r\npedia\r\n", "0\r\nX-Check"},
+			chunks: []reader.Chunk{
+				{Size: 4, Seen: at(0)},
+				{Size: 5, Seen: at(0)},
+				{Size: 0, Seen: at(10)},
+			},
+		},
+		{
+			name:     "decoded",
+			packets:  []string{header + "4;part=1\r\nWiki\r\n", "5\r\npedia\r\n", "0\r\nX-Checksum: abc\r\n\r\n"},
+			trailers: http.Header{"X-Checksum": {"abc"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := reader.New()
+			r.RawFraming = test.raw
+			r.ReadStream(newReader([]string{"GET / HTTP/1.1\r\n\r\n"}), ipFlow, portFlow, nil)
+			server := &timedReader{packets: test.packets}
+			for i := range test.packets {
+				server.times = append(server.times, at(i*10))
+			}
+			r.ReadStream(server, ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+			conversations := r.GetConversations()
+			if len(conversations) != 1 {
+				t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+			}
+			c := conversations[0]
+			if string(c.ResponseBody) != "Wikipedia" {
+				t.Errorf("Expected the decoded body, got %q", c.ResponseBody)
+			}
+			if diff := cmp.Diff(c.ResponseChunks, test.chunks); diff != "" {
+				t.Errorf("Chunks don't match (-got +expected):\n%s\n", diff)
+			}
+			trailers := http.Header{}
+			for k, v := range c.Response.Trailer {
+				if v != nil {
+					trailers[k] = v
+				}
+			}
+			if len(trailers) == 0 {
+				trailers = nil
+			}
+			if diff := cmp.Diff(trailers, test.trailers); diff != "" {
+				t.Errorf("Trailers don't match (-got +expected):\n%s\n", diff)
+			}
+		})
+	}
+}
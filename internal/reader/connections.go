@@ -0,0 +1,195 @@
+package reader
+
+import (
+	"sort"
+	"time"
+)
+
+// StreamConversations has the conversations on each connection sent on the
+// channel returned once both sides of the connection have been read, rather
+// than being held on to for GetConversations.  It needs to be called before
+// any streams are read, and the channel is closed by Finish on the
+// StreamFactory once everything has been read.
+func (h *HTTPConversationReaders) StreamConversations() <-chan Conversation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.out = make(chan Conversation, 64)
+	return h.out
+}
+
+// GetConversations returns all the conversations read.  When they're being
+// streamed it only has those not already sent.
+func (h *HTTPConversationReaders) GetConversations() []Conversation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var conversations []Conversation
+	for _, address := range h.connections() {
+		conversations = append(conversations, h.takeConnection(address)...)
+	}
+	return conversations
+}
+
+// connectionFinished sends the conversations on the connection once both
+// sides have been read.  It's called as each stream is closed.
+func (h *HTTPConversationReaders) connectionFinished(address ConversationAddress) {
+	h.mu.Lock()
+	if h.out == nil {
+		h.mu.Unlock()
+		return
+	}
+	other, ok := h.streams[address.reverse()]
+	if !ok || !other.closed {
+		// wait for the other side, or for Finish if it never turns up.
+		h.mu.Unlock()
+		return
+	}
+	conversations := h.takeConnection(address)
+	h.mu.Unlock()
+	for _, c := range conversations {
+		h.out <- c
+	}
+}
+
+// finish sends the conversations left once all the streams have been read,
+// and closes the channel.
+func (h *HTTPConversationReaders) finish() {
+	h.mu.Lock()
+	if h.out == nil {
+		h.mu.Unlock()
+		return
+	}
+	var conversations []Conversation
+	for _, address := range h.connections() {
+		conversations = append(conversations, h.takeConnection(address)...)
+	}
+	out := h.out
+	h.out = nil
+	h.mu.Unlock()
+	for _, c := range conversations {
+		out <- c
+	}
+	close(out)
+}
+
+// connections lists the connections we have conversations or TLS
+// handshakes for.  h.mu must be held.
+func (h *HTTPConversationReaders) connections() []ConversationAddress {
+	var addresses []ConversationAddress
+	for address := range h.conversations {
+		addresses = append(addresses, address)
+	}
+	for address := range h.tlsConns {
+		if _, ok := h.conversations[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// takeConnection fills in the details that need both sides of the
+// connection, then removes it so that it isn't holding on to memory.  h.mu
+// must be held.
+func (h *HTTPConversationReaders) takeConnection(address ConversationAddress) []Conversation {
+	var conversations []Conversation
+	for _, a := range []ConversationAddress{address, address.reverse()} {
+		h.addTLSInfo(a)
+		c := h.conversations[a]
+		if len(c) > 0 {
+			if hs, ok := h.handshakes[a]; ok {
+				c[0].Handshake = hs
+			}
+			h.resolveHostname(a, c)
+			h.releaseBodies(c)
+			h.keepBackendCalls(a, c)
+			h.matchBackendCalls(c)
+			conversations = append(conversations, c...)
+		}
+		delete(h.conversations, a)
+		delete(h.tlsConns, a)
+		delete(h.handshakes, a)
+		h.releaseLookup(a)
+		delete(h.fcgiIDs, a)
+		delete(h.streams, a)
+		// a later connection may have reused the ports.
+		client := a
+		client.ISN = 0
+		if isn, ok := h.isns[client]; ok && isn == a.ISN {
+			delete(h.isns, client)
+		}
+	}
+	h.dropBackendCalls()
+	return conversations
+}
+
+// claimLookup gives the latest DNS lookup of the server to the connection
+// being opened, if no other connection has used it, as that's the
+// connection that had to wait for it.  h.mu must be held.
+func (h *HTTPConversationReaders) claimLookup(address ConversationAddress) {
+	lookups := h.dnsLookups[address.IP.Dst().String()]
+	if len(lookups) == 0 {
+		return
+	}
+	lookup := lookups[len(lookups)-1]
+	if _, ok := h.dnsClaimed[lookup]; ok {
+		return
+	}
+	h.dnsClaimed[lookup] = true
+	h.dnsWaits[address] = lookup
+}
+
+// releaseLookup lets go of the lookup the connection from address waited
+// for.  It stays claimed while it's the latest lookup of the server so that
+// later connections don't claim it too.  h.mu must be held.
+func (h *HTTPConversationReaders) releaseLookup(address ConversationAddress) {
+	lookup, ok := h.dnsWaits[address]
+	if !ok {
+		return
+	}
+	delete(h.dnsWaits, address)
+	lookups := h.dnsLookups[address.IP.Dst().String()]
+	if len(lookups) > 0 && lookups[len(lookups)-1] == lookup {
+		h.dnsClaimed[lookup] = false
+		return
+	}
+	delete(h.dnsClaimed, lookup)
+}
+
+// resolveHostname fills in the hostname for the conversations on the
+// connection from address when the server was looked up in DNS.  h.mu must
+// be held.
+func (h *HTTPConversationReaders) resolveHostname(address ConversationAddress, conversations []Conversation) {
+	lookups := h.dnsLookups[address.IP.Dst().String()]
+	sort.SliceStable(lookups, func(i, j int) bool {
+		return lookups[i].Answer.Before(lookups[j].Answer)
+	})
+	start := connectionStart(conversations)
+	// the latest lookup before the connection was started.
+	i := sort.Search(len(lookups), func(i int) bool {
+		return lookups[i].Answer.After(start)
+	}) - 1
+	if i < 0 {
+		return
+	}
+	for n := range conversations {
+		conversations[n].Hostname = lookups[i].Name
+	}
+	if lookup, ok := h.dnsWaits[address]; ok {
+		conversations[0].DNS = &lookup
+	}
+}
+
+// connectionStart is the time the connection was opened, or the first time
+// something was seen on it.
+func connectionStart(conversations []Conversation) time.Time {
+	if hs := conversations[0].Handshake; hs != nil && !hs.SYN.IsZero() {
+		return hs.SYN
+	}
+	c := conversations[0]
+	if len(c.RequestSeen) > 0 {
+		return c.RequestSeen[0]
+	}
+	if len(c.ResponseSeen) > 0 {
+		return c.ResponseSeen[0]
+	}
+	return time.Time{}
+}
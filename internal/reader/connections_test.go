@@ -0,0 +1,51 @@
+package reader_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/gopacket"
+)
+
+func TestStreamConversations(t *testing.T) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+	otherPortFlow := gopacket.NewFlow(4, []byte{0xc3, 0x51}, []byte{0x1f, 0x90})
+
+	r := reader.New()
+	conversations := r.StreamConversations()
+
+	r.ReadStream(newReader([]string{"GET /both HTTP/1.1\r\n\r\n"}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"}),
+		ipFlow.Reverse(), portFlow.Reverse(), nil)
+	select {
+	case c := <-conversations:
+		if c.Request.RequestURI != "/both" || c.Response == nil {
+			t.Errorf("Expected /both with its response, got %#v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Conversation wasn't sent once both sides were read")
+	}
+
+	// without the response we don't know it's finished until the end.
+	r.ReadStream(newReader([]string{"GET /one-sided HTTP/1.1\r\n\r\n"}), ipFlow, otherPortFlow, nil)
+	select {
+	case c := <-conversations:
+		t.Fatalf("Unexpected conversation before the end %#v", c)
+	default:
+	}
+
+	go reader.NewStreamFactory(r).Finish()
+	var paths []string
+	for c := range conversations {
+		paths = append(paths, c.Request.RequestURI)
+	}
+	if len(paths) != 1 || paths[0] != "/one-sided" {
+		t.Errorf("Expected /one-sided once everything was read, got %v", paths)
+	}
+	if c := r.GetConversations(); len(c) != 0 {
+		t.Errorf("Expected the conversations to have been let go, got %d", len(c))
+	}
+}
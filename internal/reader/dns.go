@@ -0,0 +1,65 @@
+package reader
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// DNSLookup is a DNS query and the answer to it.
+type DNSLookup struct {
+	// Name that was looked up.
+	Name string
+	// Query is zero if the query wasn't captured.
+	Query  time.Time
+	Answer time.Time
+}
+
+type dnsQuery struct {
+	id   uint16
+	name string
+}
+
+// ObserveDNS records the DNS lookups so that the connections that follow
+// them know the hostname and how long the lookup took.  It should be given
+// the DNS packets in the order they were seen.
+func (h *HTTPConversationReaders) ObserveDNS(dns *layers.DNS, seen time.Time) {
+	if len(dns.Questions) == 0 {
+		return
+	}
+	name := strings.TrimSuffix(string(dns.Questions[0].Name), ".")
+	query := dnsQuery{id: dns.ID, name: name}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !dns.QR {
+		h.dnsQueries[query] = seen
+		return
+	}
+	queried := h.dnsQueries[query]
+	delete(h.dnsQueries, query)
+	if dns.ResponseCode != layers.DNSResponseCodeNoErr {
+		return
+	}
+	for _, answer := range dns.Answers {
+		if answer.Type != layers.DNSTypeA && answer.Type != layers.DNSTypeAAAA {
+			continue
+		}
+		ip := answer.IP.String()
+		if lookups := h.dnsLookups[ip]; len(lookups) > 0 {
+			// the lookup this replaces can't be claimed any more, so
+			// there's no need to remember it was once nobody's waiting
+			// for it.
+			latest := lookups[len(lookups)-1]
+			if open, ok := h.dnsClaimed[latest]; ok && !open {
+				delete(h.dnsClaimed, latest)
+			}
+		}
+		h.dnsLookups[ip] = append(h.dnsLookups[ip], DNSLookup{
+			Name:   name,
+			Query:  queried,
+			Answer: seen,
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package reader_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestObserveDNS(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	question := []layers.DNSQuestion{{Name: []byte("example.test"), Type: layers.DNSTypeA}}
+
+	r := reader.New()
+	r.ObserveDNS(&layers.DNS{ID: 7, Questions: question}, at(0))
+	r.ObserveDNS(&layers.DNS{ID: 7, QR: true, Questions: question, Answers: []layers.DNSResourceRecord{
+		{Name: []byte("example.test"), Type: layers.DNSTypeCNAME},
+		{Name: []byte("cdn.example.test"), Type: layers.DNSTypeA, IP: net.IPv4(10, 0, 0, 2)},
+	}}, at(5))
+
+	ipFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+	// the second connection re-uses the answer.
+	for i, port := range []layers.TCPPort{50000, 50001} {
+		portFlow, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(port),
+			layers.NewTCPPortEndpoint(80))
+		r.ReadStream(newTimedReader("GET / HTTP/1.1\r\n\r\n", at(10+i*10)), ipFlow, portFlow, nil)
+	}
+
+	conversations := r.GetConversations()
+	if len(conversations) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+	}
+	var lookups []*reader.DNSLookup
+	for _, c := range conversations {
+		if c.Hostname != "example.test" {
+			t.Errorf("Expected hostname example.test, got %q", c.Hostname)
+		}
+		if c.DNS != nil {
+			lookups = append(lookups, c.DNS)
+			if !c.RequestSeen[0].Equal(at(10)) {
+				t.Errorf("Expected the lookup on the first connection, got it on one at %s", c.RequestSeen[0])
+			}
+		}
+	}
+	expected := []*reader.DNSLookup{{Name: "example.test", Query: at(0), Answer: at(5)}}
+	if diff := cmp.Diff(lookups, expected); diff != "" {
+		t.Errorf("Lookups don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestObserveDNSStreamed(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	question := []layers.DNSQuestion{{Name: []byte("example.test"), Type: layers.DNSTypeA}}
+	ipFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2})
+
+	r := reader.New()
+	conversations := r.StreamConversations()
+	lookup := func(id uint16, queried, answered int) {
+		r.ObserveDNS(&layers.DNS{ID: id, Questions: question}, at(queried))
+		r.ObserveDNS(&layers.DNS{ID: id, QR: true, Questions: question, Answers: []layers.DNSResourceRecord{
+			{Name: []byte("example.test"), Type: layers.DNSTypeA, IP: net.IPv4(10, 0, 0, 2)},
+		}}, at(answered))
+	}
+	connect := func(port layers.TCPPort, seen int) *reader.DNSLookup {
+		t.Helper()
+		portFlow, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(port),
+			layers.NewTCPPortEndpoint(80))
+		r.ReadStream(newTimedReader("GET / HTTP/1.1\r\n\r\n", at(seen)), ipFlow, portFlow, nil)
+		r.ReadStream(newTimedReader("HTTP/1.1 204 No Content\r\n\r\n", at(seen+1)),
+			ipFlow.Reverse(), portFlow.Reverse(), nil)
+		select {
+		case c := <-conversations:
+			return c.DNS
+		case <-time.After(time.Second):
+			t.Fatal("Conversation wasn't sent once both sides were read")
+		}
+		return nil
+	}
+
+	lookup(1, 0, 5)
+	if l := connect(50000, 10); l == nil || !l.Answer.Equal(at(5)) {
+		t.Errorf("Expected the first connection to have waited for the lookup, got %v", l)
+	}
+	// the lookup is still used up once the connection that waited for it
+	// has gone.
+	if l := connect(50001, 20); l != nil {
+		t.Errorf("Expected the second connection not to have waited for a lookup, got %v", l)
+	}
+	lookup(2, 30, 32)
+	if l := connect(50002, 40); l == nil || !l.Answer.Equal(at(32)) {
+		t.Errorf("Expected the third connection to have waited for the new lookup, got %v", l)
+	}
+}
@@ -0,0 +1,130 @@
+package reader
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colinnewell/pcap-cli/tcp"
+)
+
+// ServerSentEvent is an event from a text/event-stream response.
+type ServerSentEvent struct {
+	// Time the last of the event was seen.
+	Time  time.Time
+	ID    string
+	Event string
+	Data  string
+	// Retry is the reconnection time in milliseconds the server asked for,
+	// -1 when it wasn't set.
+	Retry int
+}
+
+func isEventStream(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// eventStreamParser picks out the events as the body is written to it,
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+type eventStreamParser struct {
+	t      *tcp.TimeCaptureReader
+	events []ServerSentEvent
+	line   []byte
+	// skipLF is set when the last line ended with a CR, in case it was a
+	// CRLF split across writes.
+	skipLF bool
+	// the fields for the event being read.  The id carries on to following
+	// events.
+	id, event string
+	data      strings.Builder
+	retry     int
+	started   bool
+}
+
+func newEventStreamParser(t *tcp.TimeCaptureReader) *eventStreamParser {
+	return &eventStreamParser{t: t, retry: -1}
+}
+
+func (p *eventStreamParser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if p.skipLF {
+			p.skipLF = false
+			if b[0] == '\n' {
+				b = b[1:]
+				continue
+			}
+		}
+		i := bytes.IndexAny(b, "\r\n")
+		if i < 0 {
+			p.line = append(p.line, b...)
+			break
+		}
+		p.line = append(p.line, b[:i]...)
+		p.skipLF = b[i] == '\r'
+		b = b[i+1:]
+		p.processLine(string(p.line))
+		p.line = p.line[:0]
+	}
+	return n, nil
+}
+
+func (p *eventStreamParser) processLine(line string) {
+	if line == "" {
+		p.dispatch()
+		return
+	}
+	if line[0] == ':' {
+		// comment.
+		return
+	}
+	field, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+	switch field {
+	case "event":
+		p.event = value
+	case "data":
+		p.data.WriteString(value)
+		p.data.WriteByte('\n')
+		p.started = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			p.id = value
+		}
+	case "retry":
+		if retry, err := strconv.Atoi(value); err == nil && retry >= 0 {
+			p.retry = retry
+		}
+	}
+}
+
+func (p *eventStreamParser) dispatch() {
+	defer func() {
+		p.event = ""
+		p.data.Reset()
+		p.retry = -1
+		p.started = false
+	}()
+	// browsers don't dispatch events without any data, but a block setting
+	// the retry time is worth keeping.
+	if !p.started && p.retry < 0 {
+		return
+	}
+	e := ServerSentEvent{
+		ID:    p.id,
+		Event: p.event,
+		Data:  strings.TrimSuffix(p.data.String(), "\n"),
+		Retry: p.retry,
+	}
+	if e.Event == "" {
+		e.Event = "message"
+	}
+	if seen := p.t.Seen(); len(seen) > 0 {
+		e.Time = seen[len(seen)-1]
+	}
+	p.events = append(p.events, e)
+}
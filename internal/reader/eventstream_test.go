@@ -0,0 +1,47 @@
+package reader_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestEventStreamRead(t *testing.T) {
+	start := time.Date(2020, 5, 19, 15, 10, 48, 0, time.UTC)
+	response := &timedReader{
+		packets: []string{
+			"HTTP/1.1 200 OK\r\nContent-Type: text/event-stream; charset=utf-8\r\n\r\n" +
+				": keep alive\n\ndata: first\n\n",
+			"id: 2\r\nevent: update\r\ndata: line one\r\ndata:line two\r",
+			"\n\r\nretry: 5000\n\n",
+			"data: after retry\n\n",
+		},
+		times: []time.Time{start, start.Add(time.Second), start.Add(2 * time.Second), start.Add(3 * time.Second)},
+	}
+	request := newReader([]string{"GET /events HTTP/1.1\r\nHost: example.test\r\n\r\n"})
+
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0x23, 0x36}, []byte{0x1f, 0x90})
+
+	r := reader.New()
+	r.ReadStream(request, ipFlow, portFlow, nil)
+	r.ReadStream(response, ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	expected := []reader.ServerSentEvent{
+		{Time: start, Event: "message", Data: "first", Retry: -1},
+		{Time: start.Add(2 * time.Second), ID: "2", Event: "update", Data: "line one\nline two", Retry: -1},
+		{Time: start.Add(2 * time.Second), ID: "2", Event: "message", Retry: 5000},
+		{Time: start.Add(3 * time.Second), ID: "2", Event: "message", Data: "after retry", Retry: -1},
+	}
+	if diff := cmp.Diff(conversations[0].Events, expected); diff != "" {
+		t.Errorf("Events don't match (-got +expected):\n%s\n", diff)
+	}
+}
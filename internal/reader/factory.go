@@ -0,0 +1,64 @@
+package reader
+
+import (
+	"io"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// StreamFactory implements tcpassembly.StreamFactory, reading each new stream
+// with the HTTPConversationReaders.
+//
+// The streams are registered as they are created so that the reader knows
+// which connections have had both sides captured.
+type StreamFactory struct {
+	h         *HTTPConversationReaders
+	wg        sync.WaitGroup
+	completed chan interface{}
+}
+
+// NewStreamFactory returns a StreamFactory feeding h.
+func NewStreamFactory(h *HTTPConversationReaders) *StreamFactory {
+	return &StreamFactory{
+		h:         h,
+		completed: make(chan interface{}),
+	}
+}
+
+// New implements tcpassembly.StreamFactory.
+func (f *StreamFactory) New(a, b gopacket.Flow) tcpassembly.Stream {
+	r := NewReaderStream()
+	r.LossErrors = true
+	address := f.h.streamOpened(a, b)
+	r.waiting = func(waiting bool) {
+		f.h.streamWaiting(address, waiting)
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.h.readStream(&r, address)
+	}()
+	return &r
+}
+
+// Output should be called once all the packets have been assembled, and the
+// assembler flushed.  It calls outputFunc, closing the completed channel
+// passed to it once all the streams have been read.
+func (f *StreamFactory) Output(w io.Writer, outputFunc func(io.Writer, chan interface{})) {
+	go func() {
+		f.Finish()
+		close(f.completed)
+	}()
+	outputFunc(w, f.completed)
+}
+
+// Finish should be called once all the packets have been assembled, and the
+// assembler flushed.  It waits for all the streams to be read, then sends
+// the conversations left to StreamConversations and closes its channel.
+func (f *StreamFactory) Finish() {
+	f.h.assemblyComplete()
+	f.wg.Wait()
+	f.h.finish()
+}
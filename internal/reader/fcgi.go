@@ -1,48 +1,282 @@
 package reader
 
 import (
-	"io"
+	"bufio"
 	"net/http"
-
-	"github.com/google/gopacket"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/colinnewell/pcap-cli/tcp"
 	"github.com/colinnewell/pcap2har-go/internal/go/fcgi"
 )
 
+// FCGIError is something the application wrote to stderr while handling a
+// FastCGI request.
+type FCGIError struct {
+	Message string
+	// Seen is when the packet ending the message arrived.
+	Seen time.Time
+}
+
 type FCGIInfoGatherer struct {
-	a, b gopacket.Flow
-	t    *tcp.TimeCaptureReader
-	h    *HTTPConversationReaders
+	address ConversationAddress
+	t       *tcp.TimeCaptureReader
+	h       *HTTPConversationReaders
+	mu      sync.Mutex
+	// responses are waiting for the end of their requests, so that the
+	// status can go with them, by request ID.
+	responses map[uint16]*fcgiResponse
+	// requests has the last request read with each ID.
+	requests map[uint16]int
+	// seen has the times of the packets read for each request ID since
+	// its request or response was passed on, and last is when the packet
+	// the latest record came from was seen.
+	seen map[uint16][]time.Time
+	last time.Time
+}
+
+// fcgiResponse is what's been read so far of the response to a request.
+type fcgiResponse struct {
+	response func(*Conversation)
+	errors   []FCGIError
 }
 
-func NewFCGIInfoGatherer(h *HTTPConversationReaders, t *tcp.TimeCaptureReader, a, b gopacket.Flow) *FCGIInfoGatherer {
+func NewFCGIInfoGatherer(h *HTTPConversationReaders, t *tcp.TimeCaptureReader, address ConversationAddress) *FCGIInfoGatherer {
 	return &FCGIInfoGatherer{
-		a: a,
-		b: b,
-		h: h,
-		t: t,
+		address:   address,
+		h:         h,
+		t:         t,
+		responses: make(map[uint16]*fcgiResponse),
+		requests:  make(map[uint16]int),
+		seen:      make(map[uint16][]time.Time),
+	}
+}
+
+// Received puts the packets read for the record down to its request ID, as
+// the requests on a connection can follow one another or be multiplexed.  A
+// record that came in the same packet as the one before it is put down to
+// that packet.
+func (d *FCGIInfoGatherer) Received(id uint16) {
+	times := d.t.Seen()
+	d.t.Reset()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(times) > 0 {
+		d.last = times[len(times)-1]
+	} else if !d.last.IsZero() {
+		times = []time.Time{d.last}
+	}
+	if id == 0 {
+		// management records aren't part of a request.
+		return
+	}
+	seen := d.seen[id]
+	for _, t := range times {
+		if len(seen) == 0 || !seen[len(seen)-1].Equal(t) {
+			seen = append(seen, t)
+		}
 	}
+	d.seen[id] = seen
 }
 
-func (d *FCGIInfoGatherer) ErrorInfo(errString string) {
-	d.h.addErrorToResponse(d.a, d.b, errString)
+// take returns the times of the packets read for the request ID, starting
+// afresh for whatever comes next with it.  d.mu must be held.
+func (d *FCGIInfoGatherer) take(id uint16) []time.Time {
+	seen := d.seen[id]
+	delete(d.seen, id)
+	return seen
 }
 
-func (d *FCGIInfoGatherer) RequestInfo(req *http.Request) {
+func (d *FCGIInfoGatherer) ErrorInfo(id uint16, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := d.response(id)
+	r.errors = append(r.errors, FCGIError{Message: message, Seen: d.last})
+}
+
+func (d *FCGIInfoGatherer) RequestInfo(id uint16, req *http.Request) {
 	defer req.Body.Close()
-	body, _ := io.ReadAll(req.Body)
-	d.h.addRequest(d.a, d.b, req, body, d.t.Seen())
+	raw, _ := d.h.readBody(req.Body)
+	n := d.h.addRequest(d.address, req, nil)
+	params := fcgi.ProcessEnv(req)
+	if d.h.FCGIRawParams {
+		params = fcgi.Params(req)
+	}
+	d.h.fastCGIRequest(d.address, n, id, params, fcgiHeaders(req))
+	d.mu.Lock()
+	seen := d.take(id)
+	d.requests[id] = n
+	d.mu.Unlock()
+	d.h.setRequestBody(d.address, n, d.h.decodeBody(req.Header, raw), MessageSize{Body: raw.written}, seen)
+}
+
+func (d *FCGIInfoGatherer) ResponseInfo(id uint16, resp *http.Response, header, body []byte) {
+	raw := d.h.bufferBody(body)
+	b := d.h.decodeBody(resp.Header, raw)
+	size := MessageSize{Body: raw.written}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen := d.take(id)
+	if r, ok := d.responses[id]; ok && r.response != nil {
+		// the end of the last request with this ID was lost.
+		d.complete(id, nil)
+	}
+	headers := WireHeaders(header)
+	d.response(id).response = func(c *Conversation) {
+		d.h.setResponse(c, resp, b, size, seen)
+		c.ResponseHeaders = headers
+	}
 }
 
-func (d *FCGIInfoGatherer) ResponseInfo(resp *http.Response, body []byte) {
-	d.h.addResponse(d.a, d.b, resp, body, d.t.Seen())
+// fcgiHeaders lists the headers the web server passed on as params, in the
+// order they were sent.  The names are rebuilt the same way net/http/cgi
+// does, as their original casing is lost.
+func fcgiHeaders(req *http.Request) []HeaderField {
+	params := fcgi.Params(req)
+	var headers []HeaderField
+	for _, name := range fcgi.ParamNames(req) {
+		value := params[name]
+		switch {
+		case strings.HasPrefix(name, "HTTP_"):
+			name = strings.TrimPrefix(name, "HTTP_")
+		case (name == "CONTENT_TYPE" || name == "CONTENT_LENGTH") && value != "":
+			// web servers tend to send these even when they're empty.
+		default:
+			continue
+		}
+		name = textproto.CanonicalMIMEHeaderKey(strings.ReplaceAll(name, "_", "-"))
+		headers = append(headers, HeaderField{Name: name, Value: value})
+	}
+	return headers
 }
-func (d *FCGIInfoGatherer) ReturnValue(int) {
+
+// EndRequest completes the response with how the application said it
+// dealt with the request.
+func (d *FCGIInfoGatherer) EndRequest(id uint16, appStatus int, protocolStatus fcgi.ProtocolStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// the end of the request isn't part of the response.
+	d.take(id)
+	d.complete(id, func(c *Conversation) {
+		c.FCGIAppStatus = &appStatus
+		c.FCGIProtocolStatus = protocolStatus.String()
+	})
 }
 
-func (h *HTTPConversationReaders) ReadFCGIRequest(spr *tcp.SavePointReader, t *tcp.TimeCaptureReader, a, b gopacket.Flow) error {
+// AbortRequest marks the request as aborted by the web server.
+func (d *FCGIInfoGatherer) AbortRequest(id uint16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.take(id)
+	if n, ok := d.requests[id]; ok {
+		d.h.abortRequest(d.address, n)
+	}
+}
+
+// response returns the response being read for the request ID.  d.mu must
+// be held.
+func (d *FCGIInfoGatherer) response(id uint16) *fcgiResponse {
+	r, ok := d.responses[id]
+	if !ok {
+		r = &fcgiResponse{}
+		d.responses[id] = r
+	}
+	return r
+}
+
+// complete adds what's been read of the response to the request ID to its
+// conversation, along with anything from the end of the request.  d.mu
+// must be held.
+func (d *FCGIInfoGatherer) complete(id uint16, end func(*Conversation)) {
+	r := d.response(id)
+	delete(d.responses, id)
+	d.h.updateFCGIResponse(d.address, id, func(c *Conversation) {
+		if r.response != nil {
+			r.response(c)
+		}
+		c.FCGIErrors = append(c.FCGIErrors, r.errors...)
+		if end != nil {
+			end(c)
+		}
+	})
+}
+
+// flush adds the responses still waiting for the end of their requests
+// once the stream has finished.  d.mu must be held.
+func (d *FCGIInfoGatherer) flush() {
+	ids := make([]int, 0, len(d.responses))
+	for id := range d.responses {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		d.complete(uint16(id), nil)
+	}
+}
+
+func (h *HTTPConversationReaders) ReadFCGIRequest(spr *tcp.SavePointReader, t *tcp.TimeCaptureReader, address ConversationAddress) error {
 	// try to product an HTTP request from the stream
-	c := fcgi.NewChild(NewFCGIInfoGatherer(h, t, a, b))
-	return c.ReadRequest(spr)
+	d := NewFCGIInfoGatherer(h, t, address)
+	c := fcgi.NewChild(d)
+	// read what's available in one go, like the other decoders, so that
+	// trying this on something else doesn't add extra reads to the packet
+	// times.
+	err := c.ReadRequest(bufio.NewReader(spr))
+	d.mu.Lock()
+	d.flush()
+	d.mu.Unlock()
+	return err
+}
+
+// updateFCGIResponse updates the conversation for the request ID that the
+// server at address has finished responding to.  When we don't have the
+// request we fall back to the next conversation waiting for a response.
+func (h *HTTPConversationReaders) updateFCGIResponse(address ConversationAddress, id uint16, update func(*Conversation)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stream(address)
+	n, ok := h.fcgiRequest(address, id)
+	if !ok {
+		n = s.messages
+	}
+	c := h.conversation(address.reverse(), n)
+	update(c)
+	s.messages++
+	c.ResponseSkipped = append(c.ResponseSkipped, s.skipped...)
+	s.skipped = nil
+	markIncomplete(c, s, "response")
+}
+
+// fcgiRequest finds the conversation for the oldest request with the ID
+// that the server at address hasn't responded to.  Like requestMethod it
+// waits for the client side to catch up.  h.mu must be held.
+func (h *HTTPConversationReaders) fcgiRequest(address ConversationAddress, id uint16) (int, bool) {
+	s := h.stream(address)
+	s.waiting = true
+	h.cond.Broadcast()
+	defer func() {
+		s.waiting = false
+	}()
+	client := address.reverse()
+	for {
+		if waiting := h.fcgiIDs[client][id]; len(waiting) > 0 {
+			h.fcgiIDs[client][id] = waiting[1:]
+			return waiting[0], true
+		}
+		cs, ok := h.streams[client]
+		if !ok || !cs.opened || cs.closed || cs.waiting {
+			return 0, false
+		}
+		h.cond.Wait()
+	}
+}
+
+// abortRequest marks the nth request from the client at address as aborted.
+func (h *HTTPConversationReaders) abortRequest(address ConversationAddress, n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conversation(address, n).FCGIAborted = true
 }
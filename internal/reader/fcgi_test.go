@@ -0,0 +1,199 @@
+package reader_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestFCGIEndRequest(t *testing.T) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x23, 0x28})
+	params := []string{"REQUEST_METHOD", "GET", "SERVER_PROTOCOL", "HTTP/1.1", "HTTP_HOST", "localhost"}
+	request := func(id uint16, uri string) string {
+		return fcgiRequest(id, append([]string{"REQUEST_URI", uri}, params...)...)
+	}
+
+	r := reader.New()
+	r.ReadStream(newReader([]string{
+		request(1, "/busy"),
+		request(2, "/exit"),
+		request(3, "/gave-up") + fcgiRecord(fcgiAbortRequest, 3, ""),
+	}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{
+		fcgiEnd(1, 0, 2),
+		fcgiRecord(fcgiStdout, 2, "Status: 500 Internal Server Error\r\n\r\n") +
+			fcgiRecord(fcgiStdout, 2, "") + fcgiEnd(2, 255, 0),
+		fcgiEnd(3, 0, 0),
+	}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	type ending struct {
+		URI            string
+		Status         int
+		AppStatus      int
+		ProtocolStatus string
+		Aborted        bool
+	}
+
+	var got []ending
+	for _, c := range r.GetConversations() {
+		e := ending{
+			URI:            c.Request.URL.Path,
+			ProtocolStatus: c.FCGIProtocolStatus,
+			Aborted:        c.FCGIAborted,
+		}
+		if c.Response != nil {
+			e.Status = c.Response.StatusCode
+		}
+		if c.FCGIAppStatus != nil {
+			e.AppStatus = *c.FCGIAppStatus
+		} else {
+			e.AppStatus = -1
+		}
+		got = append(got, e)
+	}
+	expected := []ending{
+		{URI: "/busy", ProtocolStatus: "OVERLOADED"},
+		{URI: "/exit", Status: 500, AppStatus: 255, ProtocolStatus: "REQUEST_COMPLETE"},
+		{URI: "/gave-up", ProtocolStatus: "REQUEST_COMPLETE", Aborted: true},
+	}
+	if diff := cmp.Diff(got, expected); diff != "" {
+		t.Errorf("Endings don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestFCGIParams(t *testing.T) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x23, 0x28})
+	params := []string{
+		"REQUEST_METHOD", "GET", "REQUEST_URI", "/", "SERVER_PROTOCOL", "HTTP/1.1",
+		"HTTP_HOST", "localhost", "SCRIPT_FILENAME", "/var/www/index.php", "APP_ENV", "test",
+	}
+
+	for _, raw := range []bool{false, true} {
+		r := reader.New()
+		r.FCGIRawParams = raw
+		r.ReadStream(newReader([]string{fcgiRequest(1, params...)}), ipFlow, portFlow, nil)
+		r.ReadStream(newReader([]string{fcgiResponse(1, "", "\r\n")}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+		conversations := r.GetConversations()
+		if len(conversations) != 1 {
+			t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+		}
+		expected := map[string]string{"SCRIPT_FILENAME": "/var/www/index.php", "APP_ENV": "test"}
+		if raw {
+			expected = map[string]string{}
+			for i := 0; i < len(params); i += 2 {
+				expected[params[i]] = params[i+1]
+			}
+		}
+		if diff := cmp.Diff(conversations[0].FCGIParams, expected); diff != "" {
+			t.Errorf("Params with raw %v don't match (-got +expected):\n%s\n", raw, diff)
+		}
+	}
+}
+
+func TestFCGIMultiplexed(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x23, 0x28})
+	params := func(uri string) []string {
+		return []string{"REQUEST_METHOD", "GET", "REQUEST_URI", uri, "SERVER_PROTOCOL", "HTTP/1.1", "HTTP_HOST", "localhost"}
+	}
+
+	r := reader.New()
+	// the second request is finished first.
+	r.ReadStream(newReader([]string{
+		fcgiBegin(1) + fcgiBegin(2),
+		fcgiParamsRecords(1, params("/one")...) + fcgiParamsRecords(2, params("/two")...),
+		fcgiRecord(fcgiStdin, 2, ""),
+		fcgiRecord(fcgiStdin, 1, ""),
+	}), ipFlow, portFlow, nil)
+	// and the first answered first, with the warnings interleaved.
+	r.ReadStream(&timedReader{
+		packets: []string{
+			fcgiRecord(fcgiStderr, 1, "warning one"),
+			fcgiRecord(fcgiStderr, 2, "warning two"),
+			fcgiRecord(fcgiStdout, 1, "\r\none") + fcgiRecord(fcgiStdout, 1, "") + fcgiEnd(1, 0, 0),
+			fcgiRecord(fcgiStderr, 2, "another warning two"),
+			fcgiRecord(fcgiStdout, 2, "\r\ntwo") + fcgiRecord(fcgiStdout, 2, "") + fcgiEnd(2, 0, 0),
+		},
+		times: []time.Time{at(10), at(20), at(30), at(40), at(50)},
+	}, ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	type response struct {
+		Body   string
+		Errors []string
+	}
+	got := map[string]response{}
+	seen := map[string]time.Time{}
+	for _, c := range r.GetConversations() {
+		res := response{Body: string(c.ResponseBody)}
+		for _, e := range c.FCGIErrors {
+			res.Errors = append(res.Errors, e.Message)
+			seen[e.Message] = e.Seen
+		}
+		got[c.Request.URL.Path] = res
+	}
+	expected := map[string]response{
+		"/one": {Body: "one", Errors: []string{"warning one"}},
+		"/two": {Body: "two", Errors: []string{"warning two", "another warning two"}},
+	}
+	if diff := cmp.Diff(got, expected); diff != "" {
+		t.Errorf("Responses don't match (-got +expected):\n%s\n", diff)
+	}
+	expectedSeen := map[string]time.Time{
+		"warning one":         at(10),
+		"warning two":         at(20),
+		"another warning two": at(40),
+	}
+	if diff := cmp.Diff(seen, expectedSeen); diff != "" {
+		t.Errorf("Error times don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestFCGIHeaders(t *testing.T) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x23, 0x28})
+
+	r := reader.New()
+	r.ReadStream(newReader([]string{fcgiRequest(1,
+		"REQUEST_METHOD", "GET", "REQUEST_URI", "/", "SERVER_PROTOCOL", "HTTP/1.1",
+		"CONTENT_TYPE", "", "CONTENT_LENGTH", "",
+		"HTTP_USER_AGENT", "test", "HTTP_HOST", "localhost", "HTTP_X_REQUEST_ID", "abc",
+	)}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{fcgiResponse(1, "",
+		"Status: 404 Not Found\r\nX-Powered-By: PHP\r\nContent-Type: text/plain\r\n\r\nnope",
+	)}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	expectedRequest := []reader.HeaderField{
+		{Name: "User-Agent", Value: "test"},
+		{Name: "Host", Value: "localhost"},
+		{Name: "X-Request-Id", Value: "abc"},
+	}
+	if diff := cmp.Diff(conversations[0].RequestHeaders, expectedRequest); diff != "" {
+		t.Errorf("Request headers don't match (-got +expected):\n%s\n", diff)
+	}
+	expectedResponse := []reader.HeaderField{
+		{Name: "Status", Value: "404 Not Found"},
+		{Name: "X-Powered-By", Value: "PHP"},
+		{Name: "Content-Type", Value: "text/plain"},
+	}
+	if diff := cmp.Diff(conversations[0].ResponseHeaders, expectedResponse); diff != "" {
+		t.Errorf("Response headers don't match (-got +expected):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,51 @@
+package reader
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// TCPHandshake has the times the packets setting up a TCP connection were
+// seen.
+type TCPHandshake struct {
+	SYN    time.Time
+	SYNACK time.Time
+	ACK    time.Time
+}
+
+// Complete checks whether the whole handshake was seen.
+func (t *TCPHandshake) Complete() bool {
+	return !t.SYN.IsZero() && !t.SYNACK.IsZero() && !t.ACK.IsZero()
+}
+
+// ObserveTCP tracks the handshakes for the TCP connections so that we know
+// how long it took to connect, and which connection the streams belong to
+// when a client reuses a port.  It should be given all the TCP packets,
+// along with the time they were seen, before they're passed to the
+// assembler.
+func (h *HTTPConversationReaders) ObserveTCP(netFlow gopacket.Flow, tcp *layers.TCP, seen time.Time) {
+	port, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(tcp.SrcPort),
+		layers.NewTCPPortEndpoint(tcp.DstPort))
+	address := ConversationAddress{IP: netFlow, Port: port}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		h.isns[address] = tcp.Seq
+		address.ISN = tcp.Seq
+		h.handshakes[address] = &TCPHandshake{SYN: seen}
+	case tcp.SYN && tcp.ACK:
+		client := address.reverse()
+		client.ISN = h.isns[client]
+		if hs, ok := h.handshakes[client]; ok && hs.SYNACK.IsZero() {
+			hs.SYNACK = seen
+		}
+	case tcp.ACK && !tcp.RST:
+		address.ISN = h.isns[address]
+		if hs, ok := h.handshakes[address]; ok && !hs.SYNACK.IsZero() && hs.ACK.IsZero() {
+			hs.ACK = seen
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package reader_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestObserveTCP(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	ipFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x2})
+	client, server := layers.TCPPort(54321), layers.TCPPort(80)
+
+	r := reader.New()
+	r.ObserveTCP(ipFlow, &layers.TCP{SrcPort: client, DstPort: server, SYN: true}, start)
+	r.ObserveTCP(ipFlow.Reverse(), &layers.TCP{SrcPort: server, DstPort: client, SYN: true, ACK: true},
+		start.Add(time.Millisecond))
+	r.ObserveTCP(ipFlow, &layers.TCP{SrcPort: client, DstPort: server, ACK: true}, start.Add(2*time.Millisecond))
+	// data packets don't change the handshake.
+	r.ObserveTCP(ipFlow, &layers.TCP{SrcPort: client, DstPort: server, ACK: true, PSH: true},
+		start.Add(3*time.Millisecond))
+
+	portFlow, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(client),
+		layers.NewTCPPortEndpoint(server))
+	r.ReadStream(newReader([]string{"GET / HTTP/1.1\r\n\r\n"}), ipFlow, portFlow, nil)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	expected := &reader.TCPHandshake{
+		SYN:    start,
+		SYNACK: start.Add(time.Millisecond),
+		ACK:    start.Add(2 * time.Millisecond),
+	}
+	if diff := cmp.Diff(conversations[0].Handshake, expected); diff != "" {
+		t.Errorf("Handshake doesn't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestPortReuse(t *testing.T) {
+	start := time.Date(2020, 6, 5, 18, 17, 53, 0, time.UTC)
+	ipFlow := gopacket.NewFlow(layers.EndpointIPv4, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x2})
+	client, server := layers.TCPPort(54321), layers.TCPPort(80)
+	portFlow, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(client),
+		layers.NewTCPPortEndpoint(server))
+
+	r := reader.New()
+	for i, path := range []string{"/first", "/second"} {
+		at := start.Add(time.Duration(i) * time.Second)
+		r.ObserveTCP(ipFlow, &layers.TCP{SrcPort: client, DstPort: server, SYN: true, Seq: uint32(1000 * (i + 1))}, at)
+		r.ObserveTCP(ipFlow.Reverse(), &layers.TCP{SrcPort: server, DstPort: client, SYN: true, ACK: true},
+			at.Add(time.Millisecond))
+		r.ObserveTCP(ipFlow, &layers.TCP{SrcPort: client, DstPort: server, ACK: true}, at.Add(2*time.Millisecond))
+		r.ReadStream(newReader([]string{"GET " + path + " HTTP/1.1\r\n\r\n"}), ipFlow, portFlow, nil)
+		r.ReadStream(newReader([]string{"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"}),
+			ipFlow.Reverse(), portFlow.Reverse(), nil)
+	}
+
+	conversations := r.GetConversations()
+	if len(conversations) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+	}
+	seen := map[string]time.Time{}
+	for _, c := range conversations {
+		if c.Request == nil || c.Response == nil || c.Handshake == nil {
+			t.Fatalf("Incomplete conversation %#v", c)
+		}
+		seen[c.Request.URL.Path] = c.Handshake.SYN
+	}
+	if diff := cmp.Diff(seen, map[string]time.Time{
+		"/first":  start,
+		"/second": start.Add(time.Second),
+	}); diff != "" {
+		t.Errorf("Connections don't match (-got +expected):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,385 @@
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/colinnewell/pcap-cli/tcp"
+	"github.com/google/gopacket/tcpassembly/tcpreader"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	http2Preface   = http2.ClientPreface
+	frameHeaderLen = 9
+)
+
+var errNotHTTP2 = errors.New("not http2")
+
+// http2Stream collects the frames for one side of an HTTP/2 stream.
+type http2Stream struct {
+	headers  []hpack.HeaderField
+	trailers []hpack.HeaderField
+	body     *bodyBuffer
+	seen     []time.Time
+	// wire is the size of the stream's frames, including their headers.
+	wire int64
+	// header is the size of the HEADERS frame's payload.
+	header int64
+}
+
+// http2Side decodes the frames from one direction of an HTTP/2 connection.
+type http2Side struct {
+	h       *HTTPConversationReaders
+	address ConversationAddress
+	client  bool
+	decoder *hpack.Decoder
+	streams map[uint32]*http2Stream
+}
+
+// ReadHTTP2 try to read the stream as HTTP/2.  The client side is identified
+// by the connection preface, and the server side by the SETTINGS frame it has
+// to start with.  Once we've decided it's HTTP/2 the whole of the rest of
+// the stream is read.
+func (h *HTTPConversationReaders) ReadHTTP2(spr *tcp.SavePointReader, t *tcp.TimeCaptureReader, address ConversationAddress) error {
+	client, err := detectHTTP2(spr)
+	if err != nil {
+		return err
+	}
+	spr.Restore(true)
+	if client {
+		if _, err := io.ReadFull(spr, make([]byte, len(http2Preface))); err != nil {
+			return err
+		}
+	}
+
+	side := &http2Side{
+		h:       h,
+		address: address,
+		client:  client,
+		decoder: hpack.NewDecoder(4096, nil),
+		streams: make(map[uint32]*http2Stream),
+	}
+	// the encoder is allowed to grow the table up to the size in the
+	// peer's settings, which we may not have seen.
+	side.decoder.SetAllowedMaxDynamicTableSize(1 << 24)
+
+	fr := http2.NewFramer(nil, spr)
+	fr.SetMaxReadFrameSize(1<<24 - 1)
+	fr.ReadMetaHeaders = side.decoder
+	fr.MaxHeaderListSize = 1 << 24
+
+	for {
+		f, err := fr.ReadFrame()
+		seen := t.Seen()
+		var last time.Time
+		if len(seen) > 0 {
+			last = seen[len(seen)-1]
+		}
+		t.Reset()
+		if err != nil {
+			var se http2.StreamError
+			if errors.As(err, &se) {
+				continue
+			}
+			side.flush()
+			if err != io.EOF {
+				// we've lost track of the framing.
+				tcpreader.DiscardBytesToEOF(spr)
+			}
+			return io.EOF
+		}
+		side.handleFrame(f, last)
+	}
+}
+
+// detectHTTP2 checks for the client preface, or a SETTINGS frame from the
+// server.
+func detectHTTP2(r io.Reader) (client bool, err error) {
+	// read what's available in one go, like the bufio.Reader in the other
+	// decoders, so that we don't add extra reads to the packet times.
+	buf := make([]byte, 4096)
+	n, err := io.ReadAtLeast(r, buf, frameHeaderLen)
+	if err != nil {
+		if err == io.EOF {
+			return false, err
+		}
+		return false, errNotHTTP2
+	}
+	start := buf[:n]
+	if bytes.HasPrefix([]byte(http2Preface), start[:frameHeaderLen]) {
+		if n < len(http2Preface) {
+			rest := make([]byte, len(http2Preface)-n)
+			if _, err := io.ReadFull(r, rest); err != nil {
+				return false, errNotHTTP2
+			}
+			start = append(start, rest...)
+		}
+		if string(start[:len(http2Preface)]) != http2Preface {
+			return false, errNotHTTP2
+		}
+		return true, nil
+	}
+	length := int(start[0])<<16 | int(start[1])<<8 | int(start[2])
+	isSettings := http2.FrameType(start[3]) == http2.FrameSettings &&
+		start[4] == 0 &&
+		start[5]|start[6]|start[7]|start[8] == 0 &&
+		length%6 == 0
+	if !isSettings {
+		return false, errNotHTTP2
+	}
+	return false, nil
+}
+
+func (s *http2Side) stream(id uint32) *http2Stream {
+	st, ok := s.streams[id]
+	if !ok {
+		st = &http2Stream{body: s.h.newBodyBuffer()}
+		s.streams[id] = st
+	}
+	return st
+}
+
+func (s *http2Side) handleFrame(f http2.Frame, seen time.Time) {
+	id := f.Header().StreamID
+	switch f := f.(type) {
+	case *http2.MetaHeadersFrame:
+		st := s.stream(id)
+		st.seen = append(st.seen, seen)
+		switch {
+		case st.headers == nil:
+			if !s.client && isInformational(f.Fields) {
+				// wait for the final response.
+				return
+			}
+			st.headers = f.Fields
+			st.header = int64(f.Header().Length)
+		default:
+			st.trailers = f.Fields
+		}
+		st.wire += frameHeaderLen + int64(f.Header().Length)
+		if f.StreamEnded() {
+			s.complete(id)
+		}
+	case *http2.DataFrame:
+		st := s.stream(id)
+		st.seen = append(st.seen, seen)
+		st.wire += frameHeaderLen + int64(f.Header().Length)
+		st.body.Write(f.Data())
+		if f.StreamEnded() {
+			s.complete(id)
+		}
+	case *http2.RSTStreamFrame:
+		if _, ok := s.streams[id]; ok {
+			s.complete(id)
+		}
+	case *http2.PushPromiseFrame:
+		var fields []hpack.HeaderField
+		s.decoder.SetEmitFunc(func(f hpack.HeaderField) {
+			fields = append(fields, f)
+		})
+		if _, err := s.decoder.Write(f.HeaderBlockFragment()); err != nil {
+			return
+		}
+		if err := s.decoder.Close(); err != nil {
+			return
+		}
+		// the server makes the request on behalf of the client.
+		if req, err := http2Request(fields, nil); err == nil {
+			s.h.addHTTP2Request(s.address.reverse(), f.PromiseID, req, http2Fields(fields), body{}, MessageSize{}, []time.Time{seen})
+		}
+	}
+}
+
+// flush adds any streams that didn't complete before the end of the
+// connection.
+func (s *http2Side) flush() {
+	for id := range s.streams {
+		s.complete(id)
+	}
+}
+
+func (s *http2Side) complete(id uint32) {
+	st := s.streams[id]
+	delete(s.streams, id)
+	if st.headers == nil {
+		st.body.discard()
+		return
+	}
+	size := MessageSize{Body: st.body.written, Transfer: st.wire, Header: st.header}
+	if s.client {
+		req, err := http2Request(st.headers, st.trailers)
+		if err != nil {
+			st.body.discard()
+			return
+		}
+		s.h.addHTTP2Request(s.address, id, req, http2Fields(st.headers), s.h.decodeBody(req.Header, st.body), size, st.seen)
+		return
+	}
+	res, err := http2Response(st.headers, st.trailers)
+	if err != nil {
+		st.body.discard()
+		return
+	}
+	s.h.addHTTP2Response(s.address, id, res, http2Fields(st.headers), s.h.decodeBody(res.Header, st.body), size, st.seen)
+}
+
+func isInformational(fields []hpack.HeaderField) bool {
+	for _, f := range fields {
+		if f.Name == ":status" {
+			return len(f.Value) == 3 && f.Value[0] == '1'
+		}
+	}
+	return false
+}
+
+// http2Fields lists the headers in the order they were sent, including the
+// pseudo headers.
+func http2Fields(fields []hpack.HeaderField) []HeaderField {
+	headers := make([]HeaderField, len(fields))
+	for i, f := range fields {
+		headers[i] = HeaderField{Name: f.Name, Value: f.Value}
+	}
+	return headers
+}
+
+func http2Header(fields []hpack.HeaderField) http.Header {
+	header := http.Header{}
+	for _, f := range fields {
+		if !f.IsPseudo() {
+			header.Add(f.Name, f.Value)
+		}
+	}
+	return header
+}
+
+func http2Request(fields, trailers []hpack.HeaderField) (*http.Request, error) {
+	req := &http.Request{
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     http2Header(fields),
+	}
+	var scheme string
+	for _, f := range fields {
+		switch f.Name {
+		case ":method":
+			req.Method = f.Value
+		case ":path":
+			req.RequestURI = f.Value
+		case ":authority":
+			req.Host = f.Value
+		case ":scheme":
+			scheme = f.Value
+		}
+	}
+	if req.Host == "" {
+		req.Host = req.Header.Get("Host")
+	}
+	var err error
+	if req.RequestURI == "" {
+		// CONNECT requests don't have a path.
+		req.URL = &url.URL{Host: req.Host}
+	} else if req.URL, err = url.ParseRequestURI(req.RequestURI); err != nil {
+		return nil, err
+	}
+	req.URL.Scheme = scheme
+	if trailers != nil {
+		req.Trailer = http2Header(trailers)
+	}
+	if cl, err := strconv.ParseInt(req.Header.Get("Content-Length"), 10, 64); err == nil {
+		req.ContentLength = cl
+	}
+	return req, nil
+}
+
+func http2Response(fields, trailers []hpack.HeaderField) (*http.Response, error) {
+	res := &http.Response{
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     http2Header(fields),
+	}
+	for _, f := range fields {
+		if f.Name == ":status" {
+			code, err := strconv.Atoi(f.Value)
+			if err != nil {
+				return nil, err
+			}
+			res.StatusCode = code
+			res.Status = f.Value + " " + http.StatusText(code)
+		}
+	}
+	if res.StatusCode == 0 {
+		return nil, errors.New("http2: response missing :status")
+	}
+	if trailers != nil {
+		res.Trailer = http2Header(trailers)
+	}
+	if cl, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64); err == nil {
+		res.ContentLength = cl
+	}
+	return res, nil
+}
+
+func (h *HTTPConversationReaders) addHTTP2Request(address ConversationAddress, streamID uint32, req *http.Request, headers []HeaderField, b body, size MessageSize, seen []time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cs := h.tlsState(address); cs != nil {
+		req.TLS = cs
+	}
+	c := h.http2Conversation(address, streamID)
+	c.Request = req
+	c.RequestHeaders = headers
+	c.setRequestBody(b)
+	c.RequestSize = size
+	c.RequestSeen = seen
+}
+
+func (h *HTTPConversationReaders) addHTTP2Response(address ConversationAddress, streamID uint32, res *http.Response, headers []HeaderField, b body, size MessageSize, seen []time.Time) {
+	address = address.reverse()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cs := h.tlsState(address); cs != nil {
+		res.TLS = cs
+	}
+	c := h.http2Conversation(address, streamID)
+	c.Response = res
+	c.ResponseHeaders = headers
+	c.setResponseBody(b)
+	c.ResponseSize = size
+	c.ResponseSeen = seen
+}
+
+// http2Conversation finds the conversation for the stream, adding one if
+// necessary.  h.mu must be held.
+func (h *HTTPConversationReaders) http2Conversation(address ConversationAddress, streamID uint32) *Conversation {
+	conversations := h.conversations[address]
+	for n := range conversations {
+		if conversations[n].StreamID == streamID {
+			return &conversations[n]
+		}
+	}
+	if streamID == 1 {
+		// after an upgrade from HTTP/1.1 the response to the original
+		// request is sent on stream 1.
+		for n := range conversations {
+			c := &conversations[n]
+			if c.StreamID == 0 && c.Response != nil &&
+				c.Response.StatusCode == http.StatusSwitchingProtocols &&
+				c.Response.Header.Get("Upgrade") == "h2c" {
+				c.StreamID = streamID
+				return c
+			}
+		}
+	}
+	h.conversations[address] = append(conversations, Conversation{
+		Address:  address,
+		StreamID: streamID,
+	})
+	return &h.conversations[address][len(conversations)]
+}
@@ -0,0 +1,196 @@
+package reader_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// http2Writer builds up the frames one side of an HTTP/2 connection sends.
+type http2Writer struct {
+	buf     bytes.Buffer
+	framer  *http2.Framer
+	headers bytes.Buffer
+	encoder *hpack.Encoder
+}
+
+func newHTTP2Writer(client bool) *http2Writer {
+	w := &http2Writer{}
+	if client {
+		w.buf.WriteString(http2.ClientPreface)
+	}
+	w.framer = http2.NewFramer(&w.buf, nil)
+	w.encoder = hpack.NewEncoder(&w.headers)
+	if err := w.framer.WriteSettings(); err != nil {
+		panic(err)
+	}
+	return w
+}
+
+func (w *http2Writer) writeHeaders(streamID uint32, endStream bool, fields ...string) {
+	w.headers.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		if err := w.encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]}); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: w.headers.Bytes(),
+		EndStream:     endStream,
+		EndHeaders:    true,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func (w *http2Writer) writeData(streamID uint32, data string) {
+	if err := w.framer.WriteData(streamID, true, []byte(data)); err != nil {
+		panic(err)
+	}
+}
+
+func TestHTTP2StreamRead(t *testing.T) {
+	client := newHTTP2Writer(true)
+	client.writeHeaders(1, true,
+		":method", "GET", ":scheme", "http", ":authority", "example.test", ":path", "/first")
+	client.writeHeaders(3, false,
+		":method", "POST", ":scheme", "http", ":authority", "example.test", ":path", "/second",
+		"content-type", "text/plain")
+	client.writeData(3, "hi")
+
+	// answer the requests out of order.
+	server := newHTTP2Writer(false)
+	server.writeHeaders(3, false, ":status", "201")
+	server.writeData(3, "created")
+	server.writeHeaders(1, false, ":status", "100")
+	server.writeHeaders(1, false, ":status", "200", "content-type", "text/plain")
+	server.writeData(1, "first")
+
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0x23, 0x36}, []byte{0x1f, 0x90})
+
+	r := reader.New()
+	r.ReadStream(newReader([]string{client.buf.String()}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{server.buf.String()}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	expected := []reader.Conversation{
+		{
+			Address: reader.ConversationAddress{IP: ipFlow, Port: portFlow},
+			Request: &http.Request{
+				Method:     "GET",
+				URL:        &url.URL{Scheme: "http", Path: "/first"},
+				Host:       "example.test",
+				Proto:      "HTTP/2.0",
+				ProtoMajor: 2,
+			},
+			Response: &http.Response{
+				Status:     "200 OK",
+				StatusCode: 200,
+				Proto:      "HTTP/2.0",
+				ProtoMajor: 2,
+				Header:     http.Header{"Content-Type": {"text/plain"}},
+			},
+			RequestBody:  []byte{},
+			ResponseBody: []byte("first"),
+			RequestSeen:  []time.Time{{}},
+			ResponseSeen: []time.Time{{}, {}, {}},
+			// the frames on the wire, without the interim response.
+			RequestSize:  reader.MessageSize{Transfer: 29, Header: 20},
+			ResponseSize: reader.MessageSize{Body: 5, Transfer: 33, Header: 10},
+			StreamID:     1,
+			RequestHeaders: []reader.HeaderField{
+				{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"},
+				{Name: ":authority", Value: "example.test"}, {Name: ":path", Value: "/first"},
+			},
+			ResponseHeaders: []reader.HeaderField{
+				{Name: ":status", Value: "200"}, {Name: "content-type", Value: "text/plain"},
+			},
+		},
+		{
+			Address: reader.ConversationAddress{IP: ipFlow, Port: portFlow},
+			Request: &http.Request{
+				Method:     "POST",
+				URL:        &url.URL{Scheme: "http", Path: "/second"},
+				Host:       "example.test",
+				Proto:      "HTTP/2.0",
+				ProtoMajor: 2,
+			},
+			Response: &http.Response{
+				Status:     "201 Created",
+				StatusCode: 201,
+				Proto:      "HTTP/2.0",
+				ProtoMajor: 2,
+				Header:     http.Header{},
+			},
+			RequestBody:  []byte("hi"),
+			ResponseBody: []byte("created"),
+			RequestSeen:  []time.Time{{}, {}},
+			ResponseSeen: []time.Time{{}, {}},
+			RequestSize:  reader.MessageSize{Body: 2, Transfer: 39, Header: 19},
+			ResponseSize: reader.MessageSize{Body: 7, Transfer: 29, Header: 4},
+			StreamID:     3,
+			RequestHeaders: []reader.HeaderField{
+				{Name: ":method", Value: "POST"}, {Name: ":scheme", Value: "http"},
+				{Name: ":authority", Value: "example.test"}, {Name: ":path", Value: "/second"},
+				{Name: "content-type", Value: "text/plain"},
+			},
+			ResponseHeaders: []reader.HeaderField{{Name: ":status", Value: "201"}},
+		},
+	}
+
+	if diff := cmp.Diff(r.GetConversations(), expected,
+		cmp.Comparer(requestCompare),
+		cmp.Comparer(flowCompare),
+		cmpopts.EquateEmpty(),
+	); diff != "" {
+		t.Errorf("Conversations don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestHTTP2Upgrade(t *testing.T) {
+	client := "GET / HTTP/1.1\r\nHost: example.test\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAoAAAAAIAAAAA\r\n\r\n"
+	// the preface is only sent once the client has seen the 101.
+	upgrade := newHTTP2Writer(true)
+
+	server := newHTTP2Writer(false)
+	// the response to the original request comes back on stream 1.
+	server.writeHeaders(1, false, ":status", "200")
+	server.writeData(1, "upgraded")
+	response := "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"
+
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0x23, 0x36}, []byte{0x1f, 0x90})
+
+	r := reader.New()
+	r.ReadStream(newReader([]string{client, upgrade.buf.String()}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{response, server.buf.String()}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	got := conversations[0]
+	if got.Request == nil || got.Request.URL.Path != "/" {
+		t.Errorf("Unexpected request %#v", got.Request)
+	}
+	if got.StreamID != 1 || got.Response == nil || got.Response.StatusCode != http.StatusOK ||
+		got.Response.ProtoMajor != 2 {
+		t.Errorf("Unexpected response %#v", got.Response)
+	}
+	if string(got.ResponseBody) != "upgraded" {
+		t.Errorf("Unexpected response body %q", got.ResponseBody)
+	}
+}
@@ -0,0 +1,58 @@
+package reader
+
+import (
+	"fmt"
+
+	"github.com/colinnewell/pcap-cli/tcp"
+)
+
+// lossStream notes the data lost from the stream, carrying on with what
+// follows rather than passing on the DataLost errors.
+type lossStream struct {
+	tcp.Stream
+	lost func(int)
+}
+
+func (l *lossStream) Read(p []byte) (int, error) {
+	for {
+		n, err := l.Stream.Read(p)
+		if err != DataLost {
+			return n, err
+		}
+		skip := -1
+		if s, ok := l.Stream.(interface{ Skip() int }); ok {
+			skip = s.Skip()
+		}
+		l.lost(skip)
+	}
+}
+
+// lossStream wraps r to record the data lost from the stream from address.
+func (h *HTTPConversationReaders) lossStream(r tcp.Stream, address ConversationAddress) tcp.Stream {
+	return &lossStream{Stream: r, lost: func(n int) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		s := h.stream(address)
+		s.incomplete = true
+		if n > 0 {
+			s.bytesMissing += int64(n)
+		}
+	}}
+}
+
+// markIncomplete flags the conversation if data was lost from the stream
+// while reading its request or response.  h.mu must be held.
+func markIncomplete(c *Conversation, s *streamState, side string) {
+	if !s.incomplete {
+		return
+	}
+	c.Incomplete = true
+	c.BytesMissing += s.bytesMissing
+	if s.bytesMissing > 0 {
+		c.Errors = append(c.Errors, fmt.Sprintf("%d bytes missing from the %s", s.bytesMissing, side))
+	} else {
+		c.Errors = append(c.Errors, fmt.Sprintf("data missing from the %s", side))
+	}
+	s.incomplete = false
+	s.bytesMissing = 0
+}
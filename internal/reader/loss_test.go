@@ -0,0 +1,91 @@
+package reader_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+// lossyReader drops the bytes in the gaps between the packets, reporting
+// the loss the way TCPReaderStream does.
+type lossyReader struct {
+	packets []string
+	gaps    []int
+	pos     int
+	lost    bool
+}
+
+func (r *lossyReader) Read(p []byte) (int, error) {
+	for r.pos < len(r.packets) && r.packets[r.pos] == "" {
+		r.pos++
+		r.lost = false
+	}
+	if r.pos >= len(r.packets) {
+		return 0, io.EOF
+	}
+	if r.gaps[r.pos] != 0 && !r.lost {
+		r.lost = true
+		return 0, reader.DataLost
+	}
+	n := copy(p, r.packets[r.pos])
+	r.packets[r.pos] = r.packets[r.pos][n:]
+	return n, nil
+}
+
+func (r *lossyReader) Skip() int {
+	return r.gaps[r.pos]
+}
+
+func (r *lossyReader) Seen() (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func TestDataLost(t *testing.T) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+
+	r := reader.New()
+	// the end of the first body is lost, along with the start of the next
+	// request.
+	r.ReadStream(&lossyReader{
+		packets: []string{
+			"POST /first HTTP/1.1\r\nContent-Length: 5\r\n\r\nhel",
+			"GET /second HTTP/1.1\r\n\r\n",
+			"GET /third HTTP/1.1\r\n\r\n",
+		},
+		gaps: []int{0, 2, 0},
+	}, ipFlow, portFlow, nil)
+
+	type lossInfo struct {
+		Path         string
+		Incomplete   bool
+		BytesMissing int64
+		Errors       []string
+	}
+	var got []lossInfo
+	for _, c := range r.GetConversations() {
+		got = append(got, lossInfo{
+			Path:         c.Request.URL.Path,
+			Incomplete:   c.Incomplete,
+			BytesMissing: c.BytesMissing,
+			Errors:       c.Errors,
+		})
+	}
+	expected := []lossInfo{
+		{
+			Path:         "/first",
+			Incomplete:   true,
+			BytesMissing: 2,
+			Errors:       []string{"2 bytes missing from the request"},
+		},
+		{Path: "/third"},
+	}
+	if diff := cmp.Diff(got, expected); diff != "" {
+		t.Errorf("Conversations don't match (-got +expected):\n%s\n", diff)
+	}
+}
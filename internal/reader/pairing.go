@@ -0,0 +1,107 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// InformationalResponse is an interim 1xx response sent ahead of the final
+// response to a request, like a 100 Continue.
+type InformationalResponse struct {
+	Response *http.Response
+	// RawHeader is the header block as it was sent.
+	RawHeader []byte
+	Seen      []time.Time
+}
+
+var errNotResponse = errors.New("not an HTTP response")
+
+// looksLikeResponse checks the stream starts with an HTTP/1.x status line
+// before we go waiting for the request it answers.
+func looksLikeResponse(buf *bufio.Reader) bool {
+	start, _ := buf.Peek(len("HTTP/1."))
+	return bytes.Equal(start, []byte("HTTP/1."))
+}
+
+// requestMethod returns the method of the request the next response from the
+// server at address answers, as that decides whether the response has a
+// body.  The two sides of a connection are read independently so we wait for
+// the client side to catch up, unless it's finished or is itself waiting for
+// more of the capture.  It returns "" when we don't have the request.
+func (h *HTTPConversationReaders) requestMethod(address ConversationAddress) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stream(address)
+	s.waiting = true
+	h.cond.Broadcast()
+	defer func() {
+		s.waiting = false
+	}()
+	client := address.reverse()
+	for {
+		if cs := h.conversations[client]; len(cs) > s.messages && cs[s.messages].Request != nil {
+			return cs[s.messages].Request.Method
+		}
+		cs, ok := h.streams[client]
+		if !ok || !cs.opened || cs.closed || cs.waiting {
+			return ""
+		}
+		h.cond.Wait()
+	}
+}
+
+// upgraded checks whether the server switched protocols in its response to
+// the nth request from the client at address.  Like requestMethod it waits
+// for the other side to catch up, and it returns false when we don't have
+// the response.
+func (h *HTTPConversationReaders) upgraded(address ConversationAddress, n int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stream(address)
+	s.waiting = true
+	h.cond.Broadcast()
+	defer func() {
+		s.waiting = false
+	}()
+	server := address.reverse()
+	for {
+		if cs := h.conversations[address]; len(cs) > n && cs[n].Response != nil {
+			return cs[n].Response.StatusCode == http.StatusSwitchingProtocols
+		}
+		ss, ok := h.streams[server]
+		if !ok || !ss.opened || ss.closed || ss.waiting {
+			return false
+		}
+		h.cond.Wait()
+	}
+}
+
+// streamWaiting notes whether the reader for the stream from address is
+// blocked waiting for more packets to be assembled.
+func (h *HTTPConversationReaders) streamWaiting(address ConversationAddress, waiting bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stream(address).waiting = waiting
+	h.cond.Broadcast()
+}
+
+// addInformational adds an interim response to the conversation waiting for
+// its final response from the server at address.
+func (h *HTTPConversationReaders) addInformational(address ConversationAddress, res *http.Response, rawHeader []byte, seen []time.Time) {
+	h.updateResponse(address, false, func(c *Conversation) {
+		c.Informational = append(c.Informational, InformationalResponse{
+			Response:  res,
+			RawHeader: rawHeader,
+			Seen:      seen,
+		})
+	})
+}
+
+// isTunnel checks whether the response agreed to a CONNECT, after which the
+// connection carries whatever is being tunnelled rather than HTTP.
+func isTunnel(method string, res *http.Response) bool {
+	return method == http.MethodConnect && res.StatusCode/100 == 2
+}
@@ -0,0 +1,194 @@
+package reader_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket"
+)
+
+type exchange struct {
+	Path          string
+	Status        int
+	Body          string
+	Informational []int
+}
+
+// startedReader lets us know when it's first read from.
+type startedReader struct {
+	*fakeReader
+	started chan struct{}
+	once    sync.Once
+}
+
+func (r *startedReader) Read(p []byte) (int, error) {
+	r.once.Do(func() { close(r.started) })
+	return r.fakeReader.Read(p)
+}
+
+// readConnection reads both sides of a connection at the same time, as they
+// would be read from a capture.
+func readConnection(t *testing.T, requests, responses []string) []exchange {
+	t.Helper()
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+
+	r := reader.New()
+	client := &startedReader{fakeReader: newReader(requests), started: make(chan struct{})}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.ReadStream(client, ipFlow, portFlow, nil)
+	}()
+	// the client side of a connection is always seen first.
+	<-client.started
+	go func() {
+		defer wg.Done()
+		r.ReadStream(newReader(responses), ipFlow.Reverse(), portFlow.Reverse(), nil)
+	}()
+	wg.Wait()
+
+	var exchanges []exchange
+	for _, c := range r.GetConversations() {
+		if c.Request == nil || c.Response == nil {
+			t.Fatalf("Incomplete conversation %#v", c)
+		}
+		e := exchange{
+			Path:   c.Request.RequestURI,
+			Status: c.Response.StatusCode,
+			Body:   string(c.ResponseBody),
+		}
+		if len(c.RequestSkipped) > 0 || len(c.ResponseSkipped) > 0 {
+			t.Errorf("Unexpected bytes skipped for %s", e.Path)
+		}
+		for _, i := range c.Informational {
+			e.Informational = append(e.Informational, i.Response.StatusCode)
+		}
+		exchanges = append(exchanges, e)
+	}
+	return exchanges
+}
+
+func TestResponseBodyRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		requests  []string
+		responses []string
+		expected  []exchange
+	}{
+		{
+			name: "HEAD",
+			requests: []string{
+				"HEAD /first HTTP/1.1\r\nHost: example.test\r\n\r\n",
+				"GET /second HTTP/1.1\r\nHost: example.test\r\n\r\n",
+			},
+			responses: []string{
+				"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n",
+				"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+			},
+			expected: []exchange{
+				{Path: "/first", Status: 200},
+				{Path: "/second", Status: 200, Body: "ok"},
+			},
+		},
+		{
+			name: "100 Continue",
+			requests: []string{
+				"POST /upload HTTP/1.1\r\nHost: example.test\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n",
+				"data",
+			},
+			responses: []string{
+				"HTTP/1.1 100 Continue\r\n\r\n",
+				"HTTP/1.1 201 Created\r\nContent-Length: 4\r\n\r\ndone",
+			},
+			expected: []exchange{
+				{Path: "/upload", Status: 201, Body: "done", Informational: []int{100}},
+			},
+		},
+		{
+			name:     "Early hints in the same packet",
+			requests: []string{"GET / HTTP/1.1\r\nHost: example.test\r\n\r\n"},
+			responses: []string{
+				"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+					"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\npage",
+			},
+			expected: []exchange{
+				{Path: "/", Status: 200, Body: "page", Informational: []int{103}},
+			},
+		},
+		{
+			name: "304 and 204",
+			requests: []string{
+				"GET /cached HTTP/1.1\r\nHost: example.test\r\n\r\n",
+				"DELETE /item HTTP/1.1\r\nHost: example.test\r\n\r\n",
+			},
+			responses: []string{
+				"HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n",
+				"HTTP/1.1 204 No Content\r\n\r\n",
+			},
+			expected: []exchange{
+				{Path: "/cached", Status: 304},
+				{Path: "/item", Status: 204},
+			},
+		},
+		{
+			name:     "CONNECT",
+			requests: []string{"CONNECT example.test:443 HTTP/1.1\r\nHost: example.test:443\r\n\r\n"},
+			responses: []string{
+				"HTTP/1.1 200 Connection established\r\n\r\n",
+				"\x16\x03\x03\x00\x02tunnelled",
+			},
+			expected: []exchange{
+				{Path: "example.test:443", Status: 200},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := readConnection(t, test.requests, test.responses)
+			if diff := cmp.Diff(got, test.expected); diff != "" {
+				t.Errorf("Conversations don't match (-got +expected):\n%s\n", diff)
+			}
+		})
+	}
+}
+
+func TestGarbledAfterInterimResponse(t *testing.T) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+
+	r := reader.New()
+	r.ReadStream(newReader([]string{
+		"POST /upload HTTP/1.1\r\nHost: example.test\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n",
+		"data",
+	}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{
+		"HTTP/1.1 100 Continue\r\n\r\n",
+		"HTTP/1.1 2x0 Garbled\r\n\r\n",
+		"HTTP/1.1 201 Created\r\nContent-Length: 4\r\n\r\ndone",
+	}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	c := conversations[0]
+	if c.Response == nil || c.Response.StatusCode != 201 || string(c.ResponseBody) != "done" {
+		t.Fatalf("Expected the final response to be read, got %#v", c.Response)
+	}
+	if len(c.Informational) != 1 {
+		t.Errorf("Expected 1 interim response, got %d", len(c.Informational))
+	}
+	expected := []reader.SkippedBytes{{Offset: 25, Length: 24}}
+	if diff := cmp.Diff(c.ResponseSkipped, expected,
+		cmpopts.IgnoreFields(reader.SkippedBytes{}, "Seen"),
+	); diff != "" {
+		t.Errorf("Skipped bytes don't match (-got +expected):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,82 @@
+package reader
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// HeaderField is a header as it was sent.
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// WireHeaders lists the headers from a raw header block in the order they
+// were sent, with their original casing.  The first line is the request or
+// status line so it's skipped.
+func WireHeaders(raw []byte) []HeaderField {
+	lines := strings.Split(string(raw), "\n")
+	var headers []HeaderField
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			// obsolete line folding continues the previous value.
+			last := &headers[len(headers)-1]
+			last.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		headers = append(headers, HeaderField{Name: name, Value: strings.TrimSpace(value)})
+	}
+	return headers
+}
+
+// headerRecorder keeps a copy of what's read from the stream so that we have
+// the header block as it was sent, since net/http canonicalises the names and
+// loses the order.  It also counts what's read so we know the size of the
+// whole message.
+type headerRecorder struct {
+	r       io.Reader
+	raw     []byte
+	start   int
+	stopped bool
+	read    int64
+}
+
+func (h *headerRecorder) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.read += int64(n)
+	if !h.stopped {
+		h.raw = append(h.raw, p[:n]...)
+	}
+	return n, err
+}
+
+// header returns the bytes buf has consumed since the last header, which
+// after parsing a request or response is its header block.
+func (h *headerRecorder) header(buf *bufio.Reader) []byte {
+	end := len(h.raw) - buf.Buffered()
+	header := h.raw[h.start:end:end]
+	h.start = end
+	return header
+}
+
+// consumed returns the number of bytes buf has consumed from the stream.
+func (h *headerRecorder) consumed(buf *bufio.Reader) int64 {
+	return h.read - int64(buf.Buffered())
+}
+
+// stop recording once we have the headers we want.  The bytes read are
+// still counted.
+func (h *headerRecorder) stop() {
+	h.stopped = true
+	h.raw = nil
+	h.start = 0
+}
@@ -2,26 +2,96 @@ package reader
 
 import (
 	"bufio"
-	"compress/gzip"
 	"io"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/colinnewell/pcap-cli/tcp"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly/tcpreader"
+
+	"github.com/colinnewell/pcap2har-go/internal/contentencoding"
+	"github.com/colinnewell/pcap2har-go/internal/tlsdecode"
 )
 
 type HTTPConversationReaders struct {
-	mu            sync.Mutex
+	mu sync.Mutex
+	// cond is signalled when a request is added or a stream changes state,
+	// for responses waiting on their requests.
+	cond          *sync.Cond
 	conversations map[ConversationAddress][]Conversation
+	streams       map[ConversationAddress]*streamState
+	tlsConns      map[ConversationAddress]*tlsdecode.Conn
+	handshakes    map[ConversationAddress]*TCPHandshake
+	// isns has the ISN of the latest SYN seen from each client address.
+	isns       map[ConversationAddress]uint32
+	dnsQueries map[dnsQuery]time.Time
+	dnsLookups map[string][]DNSLookup
+	// dnsClaimed has the lookups a connection has waited for, and whether
+	// that connection is still open.  They're kept after it's gone while
+	// they're the latest lookup of the server, so that no other connection
+	// claims them.  dnsWaits has the lookup each connection waited for.
+	dnsClaimed map[DNSLookup]bool
+	dnsWaits   map[ConversationAddress]DNSLookup
+	// backendPending has the FastCGI conversations on connections that
+	// haven't been taken yet, and whether they've been matched to an HTTP
+	// request.  backendCalls are those from connections that have been
+	// taken that are still waiting for theirs, until there aren't any open
+	// HTTP requests they could be matched to.
+	backendPending map[backendKey]bool
+	backendCalls   []backendCall
+	// fcgiIDs has the FastCGI requests from each client address waiting
+	// for their responses, by request ID.
+	fcgiIDs   map[ConversationAddress]map[uint16][]int
+	assembled bool
+	// out is where the conversations are sent once their connections are
+	// finished when they're being streamed.
+	out chan Conversation
+	// memory is how much the bodies held in memory are using.
+	memory atomic.Int64
+	Options
+}
+
+// Options provides user-settable options for the HTTPConversationReaders.
+// They should be set before any streams are read.
+type Options struct {
+	// KeyLog provides the secrets for decrypting TLS connections.  When it's
+	// nil TLS connections aren't decoded.
+	KeyLog *tlsdecode.KeyLog
+	// MaxBodySize is the most of each body that's kept, anything after it
+	// is dropped and the conversation is marked as truncated.  0 keeps the
+	// whole body.
+	MaxBodySize int64
+	// MaxMemory is roughly how much memory the bodies being held on to can
+	// use between them.  Once it's used up the bodies are spilled to
+	// temporary files in TempDir.  0 means there's no limit.
+	MaxMemory int64
+	TempDir   string
+	// RawFraming records the chunks of bodies sent with chunked transfer
+	// encoding.
+	RawFraming bool
+	// FCGIRawParams keeps all the params sent with FastCGI requests rather
+	// than just those that aren't part of the HTTP request.
+	FCGIRawParams bool
 }
 
+// ConversationAddress identifies a TCP connection, or one direction of it.
+// Clients can reuse a port for a new connection to the same server, so the
+// client's initial sequence number is used to tell them apart.
 type ConversationAddress struct {
 	IP, Port gopacket.Flow
+	// ISN is the initial sequence number from the client's SYN, or 0 if
+	// it wasn't captured.  It's the same for both directions.
+	ISN uint32
+}
+
+func (c ConversationAddress) reverse() ConversationAddress {
+	return ConversationAddress{IP: c.IP.Reverse(), Port: c.Port.Reverse(), ISN: c.ISN}
 }
+
 type Conversation struct {
 	Address      ConversationAddress
 	Request      *http.Request
@@ -30,189 +100,423 @@ type Conversation struct {
 	ResponseBody []byte
 	RequestSeen  []time.Time
 	ResponseSeen []time.Time
-	// FastCGI info if present
+	// RawRequestHeader and RawResponseHeader are the header blocks as they
+	// were sent, from the request or status line to the blank line ending
+	// the headers.  They're only set for HTTP/1.x.
+	RawRequestHeader  []byte
+	RawResponseHeader []byte
+	// RequestHeaders and ResponseHeaders are the headers in the order they
+	// were sent when there isn't a raw header block.  For HTTP/2 they
+	// include the pseudo headers, and for FastCGI requests they're made
+	// from the params.
+	RequestHeaders  []HeaderField
+	ResponseHeaders []HeaderField
+	// RequestSize and ResponseSize are the sizes of the messages as they
+	// were sent.
+	RequestSize  MessageSize
+	ResponseSize MessageSize
+	// RequestDecodeError and ResponseDecodeError explain why a body
+	// couldn't be decompressed, in which case it's left as it was sent.
+	RequestDecodeError  string
+	ResponseDecodeError string
+	// RequestBodyFile and ResponseBodyFile are set instead of RequestBody
+	// and ResponseBody when the body was spilled to a temporary file to stay
+	// within MaxMemory.  They're read from the file as they're needed.
+	RequestBodyFile  *BodyFile
+	ResponseBodyFile *BodyFile
+	// RequestTruncated and ResponseTruncated are set when the body was
+	// longer than MaxBodySize so only the start of it was kept.
+	RequestTruncated  bool
+	ResponseTruncated bool
+	// RequestChunks and ResponseChunks are the chunks the bodies were sent
+	// in when they used chunked transfer encoding and RawFraming is set.
+	RequestChunks  []Chunk
+	ResponseChunks []Chunk
+	// Informational are the interim 1xx responses sent ahead of the final
+	// Response.
+	Informational []InformationalResponse
+	// HTTP/2 stream the conversation was on, 0 for earlier versions of HTTP
+	StreamID uint32
+	// Errors are notes of any data lost from the capture.
 	Errors []string
+	// Incomplete is set when data was lost from the capture while reading
+	// the conversation, so the bodies can't be trusted.  BytesMissing is
+	// how much was lost, as far as we know.
+	Incomplete   bool
+	BytesMissing int64
+	// WebSocketMessages are the messages sent in both directions after an
+	// upgrade to a WebSocket.
+	WebSocketMessages []WebSocketMessage
+	// Events from a text/event-stream response.
+	Events []ServerSentEvent
+	// Handshake that set up the connection.  It's only set on the first
+	// conversation on the connection as the others didn't have to wait for
+	// it.
+	Handshake *TCPHandshake
+	// Hostname the client looked up in DNS to find the server.
+	Hostname string
+	// DNS lookup the connection had to wait for.  Like the Handshake it's
+	// only set on the first conversation on the connection, and only for
+	// the first connection after the lookup.
+	DNS *DNSLookup
+	// TLS has what was negotiated for a TLS connection.  When we couldn't
+	// decrypt the connection there's a single conversation for it with no
+	// Request or Response.
+	TLS *tlsdecode.Info
+	// TLSHandshake is set on the first conversation on a TLS connection as
+	// that's the one that waited for the handshake.
+	TLSHandshake bool
+	// RequestSkipped and ResponseSkipped are the parts of the streams that
+	// couldn't be decoded ahead of the request and response.  Anything
+	// skipped at the end of a stream is added to the last conversation.
+	RequestSkipped  []SkippedBytes
+	ResponseSkipped []SkippedBytes
+	// FastCGI is set when the conversation was a FastCGI request from the
+	// web server, rather than HTTP.
+	FastCGI bool
+	// FCGIAppStatus and FCGIProtocolStatus are what the application sent at
+	// the end of a FastCGI request.  FCGIAborted is set when the web server
+	// aborted the request.
+	FCGIAppStatus      *int
+	FCGIProtocolStatus string
+	FCGIAborted        bool
+	// FCGIErrors are what the application wrote to stderr while handling a
+	// FastCGI request.
+	FCGIErrors []FCGIError
+	// FCGIParams are the params sent with a FastCGI request that aren't
+	// part of the HTTP request, like SCRIPT_FILENAME, or all of them when
+	// FCGIRawParams is set.
+	FCGIParams map[string]string
+	// BackendCalls are the FastCGI requests made while handling the HTTP
+	// request, when they were captured too.
+	BackendCalls []BackendCall
 }
 
 func New() *HTTPConversationReaders {
 	conversations := make(map[ConversationAddress][]Conversation)
-	return &HTTPConversationReaders{
+	h := &HTTPConversationReaders{
 		conversations: conversations,
+		streams:       make(map[ConversationAddress]*streamState),
+		tlsConns:      make(map[ConversationAddress]*tlsdecode.Conn),
+		handshakes:    make(map[ConversationAddress]*TCPHandshake),
+		isns:          make(map[ConversationAddress]uint32),
+		dnsQueries:    make(map[dnsQuery]time.Time),
+		dnsLookups:    make(map[string][]DNSLookup),
+		dnsClaimed:    make(map[DNSLookup]bool),
+		dnsWaits:      make(map[ConversationAddress]DNSLookup),
+
+		backendPending: make(map[backendKey]bool),
+		fcgiIDs:        make(map[ConversationAddress]map[uint16][]int),
 	}
+	h.cond = sync.NewCond(&h.mu)
+	return h
 }
 
-type streamDecoder func(*tcp.SavePointReader, *tcp.TimeCaptureReader, gopacket.Flow, gopacket.Flow) error
-
-func drain(spr *tcp.SavePointReader, _ *tcp.TimeCaptureReader, _, _ gopacket.Flow) error {
-	tcpreader.DiscardBytesToEOF(spr)
-	return nil
-}
+type streamDecoder func(*tcp.SavePointReader, *tcp.TimeCaptureReader, ConversationAddress) error
 
 // ReadStream tries to read tcp connections and extract HTTP conversations.
+// TLS connections are decrypted first if we have a KeyLog, and otherwise
+// just have their handshakes read.
 func (h *HTTPConversationReaders) ReadStream(r tcp.Stream, a, b gopacket.Flow, completed chan interface{}) {
+	h.readStream(r, h.streamOpened(a, b))
+}
+
+// readStream reads the stream from address, which should already have been
+// registered with streamOpened.
+func (h *HTTPConversationReaders) readStream(r tcp.Stream, address ConversationAddress) {
+	defer h.connectionFinished(address)
+	defer h.streamClosed(address)
+	r = h.lossStream(r, address)
+	r = h.tlsStream(r, address)
 	t := tcp.NewTimeCaptureReader(r)
-	spr := tcp.NewSavePointReader(t)
+	pos := &streamPosition{r: t}
+	spr := tcp.NewSavePointReader(pos)
 	decoders := []streamDecoder{
+		h.ReadWebSocket,
+		// FastCGI reads the records as it needs them rather than reading
+		// ahead, so it goes before the others to keep the times of the
+		// packets with the requests they came with.
+		h.ReadFCGIRequest,
+		h.ReadHTTP2,
 		h.ReadHTTPRequest,
 		h.ReadHTTPResponse,
-		h.ReadFCGIRequest,
-		drain,
 	}
 	for {
+		spr.SavePoint()
+		decoded := false
 		for i, decode := range decoders {
-			err := decode(spr, t, a, b)
+			err := decode(spr, t, address)
 			if err == nil {
+				decoded = true
 				break
 			}
 			if err == io.EOF {
 				return
-			} else if err != nil {
-				// don't need to restore before the last one
-				if i+1 < len(decoders) {
-					// can discard the save point on the final restore
-					spr.Restore(i < len(decoders))
-				}
+			}
+			// can discard the save point on the final restore
+			spr.Restore(i+1 == len(decoders))
+		}
+		if !decoded {
+			// skip over what we don't understand rather than giving up
+			// on the rest of the stream.
+			var seen time.Time
+			if times := t.Seen(); len(times) > 0 {
+				seen = times[0]
+			}
+			skipped, err := resync(spr, pos)
+			skipped.Seen = seen
+			h.addSkipped(address, skipped)
+			if err != nil {
+				return
 			}
 		}
 		t.Reset()
 	}
 }
 
-func (h *HTTPConversationReaders) GetConversations() []Conversation {
-	var conversations []Conversation
-	for _, c := range h.conversations {
-		conversations = append(conversations, c...)
-	}
-	return conversations
-}
-
 // ReadHTTPResponse try to read the stream as an HTTP response.
-func (h *HTTPConversationReaders) ReadHTTPResponse(spr *tcp.SavePointReader, t *tcp.TimeCaptureReader, a, b gopacket.Flow) error {
-	buf := bufio.NewReader(spr)
+func (h *HTTPConversationReaders) ReadHTTPResponse(spr *tcp.SavePointReader, t *tcp.TimeCaptureReader, address ConversationAddress) error {
+	rec := &headerRecorder{r: spr}
+	buf := bufio.NewReader(rec)
+	if !looksLikeResponse(buf) {
+		return errNotResponse
+	}
 
-	res, err := http.ReadResponse(buf, nil)
+	// the request decides whether the response has a body.
+	method := h.requestMethod(address)
+	var req *http.Request
+	if method != "" {
+		req = &http.Request{Method: method}
+	}
+	res, err := http.ReadResponse(buf, req)
+	for err == nil && res.StatusCode/100 == 1 && res.StatusCode != http.StatusSwitchingProtocols {
+		// interim responses come ahead of the final response.
+		spr.SavePoint()
+		h.addInformational(address, res, rec.header(buf), t.Seen())
+		t.Reset()
+		res, err = http.ReadResponse(buf, req)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return io.EOF
+		}
+	}
 	if err != nil {
+		// after an interim response this skips to the final response,
+		// noting what was lost.
 		return err
 	}
+	res.Request = nil
+	rawHeader := rec.header(buf)
+	rec.stop()
+	start := rec.consumed(buf) - int64(len(rawHeader))
 
+	chunked := h.chunkedReader(buf, t, res.TransferEncoding, res.Body)
+	if chunked != nil {
+		res.Body = io.NopCloser(chunked)
+	}
 	spr.SavePoint()
 	defer res.Body.Close()
 
-	var reader io.ReadCloser
-	if res.Header.Get("Content-Encoding") == "gzip" {
-		reader, err = gzip.NewReader(res.Body)
-		if err != nil {
-			// just get it raw
-			reader = res.Body
-		} else {
-			defer reader.Close()
-		}
-	} else {
-		reader = res.Body
+	if isTunnel(method, res) {
+		// the rest of the connection is whatever's being tunnelled.
+		seen := t.Seen()
+		size := MessageSize{Transfer: int64(len(rawHeader))}
+		h.updateResponse(address, true, func(c *Conversation) {
+			h.setResponse(c, res, body{}, size, seen)
+			c.RawResponseHeader = rawHeader
+		})
+		tcpreader.DiscardBytesToEOF(buf)
+		return io.EOF
+	}
+
+	// count the body before it's decompressed.
+	encoded := &countingReader{r: res.Body}
+	compressed := len(contentencoding.Codings(res.Header)) > 0
+
+	var events *eventStreamParser
+	var bodyReader io.Reader = encoded
+	if isEventStream(res.Header) && !compressed {
+		// parse the events as they're read so we know when each one
+		// arrived.
+		events = newEventStreamParser(t)
+		bodyReader = io.TeeReader(encoded, events)
 	}
 
-	body, err := io.ReadAll(reader)
+	raw, err := h.readBody(bodyReader)
+	size := MessageSize{Body: encoded.n, Transfer: rec.consumed(buf) - start}
 	// unexpected EOF reading trailer seems to indicate truncated stream when
 	// dealing with chunked encdoing.  If we fall back to not reading it, we
 	// still have the same basic output, just with all the chunking arterfacts.
 	if err != nil && err.Error() != "http: unexpected EOF reading trailer" {
+		raw.discard()
 		spr.Restore(true)
 		buf = bufio.NewReader(spr)
-		body, err = io.ReadAll(buf)
+		raw, err = h.readBody(buf)
 		if err != nil {
 			log.Println("Got an error trying to read it raw, let's just discard")
 			tcpreader.DiscardBytesToEOF(buf)
 		}
+		size = MessageSize{Body: raw.written, Transfer: int64(len(rawHeader)) + raw.written}
+	}
+	seen := t.Seen()
+	b := h.decodeBody(res.Header, raw)
+	if chunked != nil {
+		b.chunks = chunked.chunks
+		if chunked.trailer != nil {
+			res.Trailer = chunked.trailer
+		}
+	}
+	h.updateResponse(address, true, func(c *Conversation) {
+		h.setResponse(c, res, b, size, seen)
+		c.RawResponseHeader = rawHeader
+		if isEventStream(res.Header) && compressed && c.ResponseDecodeError == "" {
+			// we can only tell when the whole of a compressed stream
+			// arrived.
+			events = newEventStreamParser(t)
+			_, _ = events.Write(c.ResponseBody)
+		}
+		if events != nil {
+			c.Events = events.events
+		}
+	})
+	if err == nil && res.StatusCode == http.StatusSwitchingProtocols && isWebSocketUpgrade(res.Header) {
+		h.readWebSocket(buf, t, address, false)
+		return io.EOF
 	}
-	h.addResponse(a, b, res, body, t.Seen())
 	return err
 }
 
 // ReadHTTPRequest try to read the stream as an HTTP request.
-func (h *HTTPConversationReaders) ReadHTTPRequest(spr *tcp.SavePointReader, t *tcp.TimeCaptureReader, a, b gopacket.Flow) error {
-	spr.SavePoint()
-	buf := bufio.NewReader(spr)
+func (h *HTTPConversationReaders) ReadHTTPRequest(spr *tcp.SavePointReader, t *tcp.TimeCaptureReader, address ConversationAddress) error {
+	rec := &headerRecorder{r: spr}
+	buf := bufio.NewReader(rec)
 
 	req, err := http.ReadRequest(buf)
 	if err != nil {
 		return err
 	}
+	rawHeader := rec.header(buf)
+	rec.stop()
 
+	chunked := h.chunkedReader(buf, t, req.TransferEncoding, req.Body)
+	if chunked != nil {
+		req.Body = io.NopCloser(chunked)
+	}
 	spr.SavePoint()
 	defer req.Body.Close()
-	body, err := io.ReadAll(req.Body)
+	// add the request before reading the body so the response can find it.
+	n := h.addRequest(address, req, rawHeader)
+	raw, err := h.readBody(req.Body)
+	size := MessageSize{Body: raw.written, Transfer: rec.consumed(buf)}
 	if err != nil {
+		raw.discard()
 		spr.Restore(true)
 		buf = bufio.NewReader(spr)
-		body, err = io.ReadAll(buf)
+		raw, err = h.readBody(buf)
 		if err != nil {
 			log.Println("Got an error trying to read it raw, let's just discard")
 			tcpreader.DiscardBytesToEOF(buf)
 		}
+		size = MessageSize{Body: raw.written, Transfer: int64(len(rawHeader)) + raw.written}
 	}
 
-	h.addRequest(a, b, req, body, t.Seen())
+	b := h.decodeBody(req.Header, raw)
+	if chunked != nil {
+		b.chunks = chunked.chunks
+		if chunked.trailer != nil {
+			req.Trailer = chunked.trailer
+		}
+	}
+	h.setRequestBody(address, n, b, size, t.Seen())
+	if err == nil && isWebSocketUpgrade(req.Header) {
+		// ReadWebSocket switches to frames if the server agrees.
+		h.requestUpgrade(address, n)
+	}
 	return err
 }
 
-func (h *HTTPConversationReaders) addRequest(a, b gopacket.Flow, req *http.Request, body []byte, seen []time.Time) {
-	address := ConversationAddress{IP: a, Port: b}
+// addRequest adds the next request read from the client at address, along
+// with its raw header block when we have it, returning its position.  The
+// requests and responses on a connection come in the same order so they're
+// paired by their position.
+func (h *HTTPConversationReaders) addRequest(address ConversationAddress, req *http.Request, rawHeader []byte) int {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	conversations := h.conversations[address]
-	for n := 0; n < len(conversations); n++ {
-		c := conversations[n]
-		if conversations[n].Request == nil {
-			c.Request = req
-			c.RequestBody = body
-			c.RequestSeen = seen
-			h.conversations[address][n] = c
-			return
-		}
+	if cs := h.tlsState(address); cs != nil {
+		req.TLS = cs
 	}
-	h.conversations[address] = append(h.conversations[address], Conversation{
-		Address:     address,
-		Request:     req,
-		RequestBody: body,
-		RequestSeen: seen,
-	})
+	s := h.stream(address)
+	n := s.messages
+	c := h.conversation(address, n)
+	s.messages++
+	s.client = true
+	c.RequestSkipped = append(c.RequestSkipped, s.skipped...)
+	s.skipped = nil
+	c.Request = req
+	c.RawRequestHeader = rawHeader
+	h.cond.Broadcast()
+	return n
 }
 
-func (h *HTTPConversationReaders) addErrorToResponse(a, b gopacket.Flow, errString string) {
-	h.updateResponse(a, b, func(c *Conversation) {
-		c.Errors = append(c.Errors, errString)
-	})
+// setRequestBody fills in the body of the nth request from the client at
+// address once it's been read and decompressed.
+func (h *HTTPConversationReaders) setRequestBody(address ConversationAddress, n int, b body, size MessageSize, seen []time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.conversation(address, n)
+	markIncomplete(c, h.stream(address), "request")
+	c.setRequestBody(b)
+	c.RequestSize = size
+	c.RequestSeen = seen
 }
 
-func (h *HTTPConversationReaders) addResponse(a, b gopacket.Flow, res *http.Response, body []byte, seen []time.Time) {
-	h.updateResponse(a, b, func(c *Conversation) {
-		c.Response = res
-		c.ResponseBody = body
-		c.ResponseSeen = seen
-	})
+// setResponse fills in the response side of the conversation once its body
+// has been read and decompressed.  h.mu must be held.
+func (h *HTTPConversationReaders) setResponse(c *Conversation, res *http.Response, b body, size MessageSize, seen []time.Time) {
+	if cs := h.tlsState(c.Address); cs != nil {
+		res.TLS = cs
+	}
+	c.Response = res
+	c.setResponseBody(b)
+	c.ResponseSize = size
+	c.ResponseSeen = seen
 }
 
-func (h *HTTPConversationReaders) updateResponse(a, b gopacket.Flow, update func(*Conversation)) {
-	address := ConversationAddress{IP: a.Reverse(), Port: b.Reverse()}
+// updateResponse updates the conversation waiting for the next response from
+// the server at address.  Once the response is complete we move on to the
+// next conversation.
+func (h *HTTPConversationReaders) updateResponse(address ConversationAddress, complete bool, update func(*Conversation)) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	conversations := h.conversations[address]
-	if conversations == nil {
-		c := Conversation{
-			Address: address,
-		}
-		update(&c)
-		h.conversations[address] = append(h.conversations[address], c)
+	s := h.stream(address)
+	c := h.conversation(address.reverse(), s.messages)
+	update(c)
+	if complete {
+		s.messages++
+		c.ResponseSkipped = append(c.ResponseSkipped, s.skipped...)
+		s.skipped = nil
+		markIncomplete(c, s, "response")
+		h.cond.Broadcast()
+	}
+}
+
+// addSkipped records the bytes skipped on the stream from address.  They're
+// added to the next request or response read from it.
+func (h *HTTPConversationReaders) addSkipped(address ConversationAddress, skipped SkippedBytes) {
+	if skipped.Length == 0 {
 		return
 	}
-	for n := 0; n < len(conversations); n++ {
-		c := conversations[n]
-		if conversations[n].Response == nil {
-			update(&c)
-			h.conversations[address][n] = c
-			break
-		}
-		// FIXME: should think about what we do when we don't find
-		// the other side of the conversation.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stream(address)
+	s.skipped = append(s.skipped, skipped)
+}
+
+// conversation returns the nth conversation on the connection from the
+// client address, adding conversations as necessary.  The pointer is only
+// valid until more are added.  h.mu must be held.
+func (h *HTTPConversationReaders) conversation(address ConversationAddress, n int) *Conversation {
+	for len(h.conversations[address]) <= n {
+		h.conversations[address] = append(h.conversations[address], Conversation{Address: address})
 	}
+	return &h.conversations[address][n]
 }
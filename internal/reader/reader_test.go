@@ -1,14 +1,27 @@
 package reader_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/colinnewell/pcap2har-go/internal/tlsdecode"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/gopacket"
@@ -35,28 +48,54 @@ func newReader(data []string) *fakeReader {
 	return &fakeReader{data: readers, pos: pos}
 }
 
-func (f fakeReader) Read(p []byte) (n int, err error) {
-	if len(f.data) < f.pos {
-		return 0, io.EOF
-	}
-
-	n, err = f.data[f.pos].Read(p)
-	if err == io.EOF {
+func (f *fakeReader) Read(p []byte) (n int, err error) {
+	for f.pos < len(f.data) {
+		n, err = f.data[f.pos].Read(p)
+		if err != io.EOF {
+			return n, err
+		}
 		f.pos++
-		if f.pos < len(f.data) {
-			n, err = f.data[f.pos].Read(p)
+		if n > 0 {
+			return n, nil
 		}
 	}
-	return
+	return 0, io.EOF
 }
 
-func (f fakeReader) Seen() (time.Time, error) {
+func (f *fakeReader) Seen() (time.Time, error) {
 	return time.Time{}, nil
 }
 
+// timedReader returns each packet in turn, along with the time it was seen.
+type timedReader struct {
+	packets []string
+	times   []time.Time
+	pos     int
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	for r.pos < len(r.packets) && r.packets[r.pos] == "" {
+		r.pos++
+	}
+	if r.pos >= len(r.packets) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.packets[r.pos])
+	r.packets[r.pos] = r.packets[r.pos][n:]
+	return n, nil
+}
+
+func (r *timedReader) Seen() (time.Time, error) {
+	return r.times[r.pos], nil
+}
+
+func newTimedReader(data string, seen time.Time) *timedReader {
+	return &timedReader{packets: []string{data}, times: []time.Time{seen}}
+}
+
 func TestHTTPStreamRead(t *testing.T) {
 	req := newReader([]string{"GET / HTTP/1.1\r\n\r\n", "GET /next HTTP/1.1\r\n\r\n"})
-	response := newReader([]string{
+	responses := []string{
 		strings.Join([]string{"HTTP/1.1 200 OK",
 			"Access-Control-Allow-Origin: *",
 			"Content-Type: application/json; charset=utf-8",
@@ -77,7 +116,12 @@ func TestHTTPStreamRead(t *testing.T) {
 			"--"},
 			"\r\n",
 		),
-	})
+	}
+	response := newReader(responses)
+	// the raw headers run up to the blank line before the body.
+	rawHeader := func(s string) []byte {
+		return []byte(s[:len(s)-len("{}")])
+	}
 	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
 		0x0, 0x0, 0x1})
 	portFlow := gopacket.NewFlow(4, []byte{0x23, 0x36}, []byte{0xa8, 0x0})
@@ -115,10 +159,14 @@ func TestHTTPStreamRead(t *testing.T) {
 				},
 				ContentLength: 2,
 			},
-			RequestBody:  []byte(""),
-			ResponseBody: []byte("{}"),
-			RequestSeen:  []time.Time{{}},
-			ResponseSeen: []time.Time{{}},
+			RequestBody:       []byte(""),
+			ResponseBody:      []byte("{}"),
+			RequestSeen:       []time.Time{{}},
+			ResponseSeen:      []time.Time{{}},
+			RawRequestHeader:  []byte("GET / HTTP/1.1\r\n\r\n"),
+			RawResponseHeader: rawHeader(responses[0]),
+			RequestSize:       reader.MessageSize{Transfer: 18},
+			ResponseSize:      reader.MessageSize{Body: 2, Transfer: int64(len(responses[0]))},
 		},
 		{
 			Address: reader.ConversationAddress{IP: ipFlow, Port: portFlow},
@@ -150,8 +198,12 @@ func TestHTTPStreamRead(t *testing.T) {
 				},
 				ContentLength: 2,
 			},
-			RequestBody:  []byte(""),
-			ResponseBody: []byte("--"),
+			RequestBody:       []byte(""),
+			ResponseBody:      []byte("--"),
+			RawRequestHeader:  []byte("GET /next HTTP/1.1\r\n\r\n"),
+			RawResponseHeader: rawHeader(responses[1]),
+			RequestSize:       reader.MessageSize{Transfer: 22},
+			ResponseSize:      reader.MessageSize{Body: 2, Transfer: int64(len(responses[1]))},
 		},
 	}
 
@@ -164,6 +216,175 @@ func TestHTTPStreamRead(t *testing.T) {
 	}
 }
 
+func TestResponsesReadFirst(t *testing.T) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+
+	// the two sides are read independently so the responses can be read
+	// before the requests.
+	r := reader.New()
+	r.ReadStream(newReader([]string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst",
+		"HTTP/1.1 404 Not Found\r\nContent-Length: 6\r\n\r\nsecond",
+	}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+	r.ReadStream(newReader([]string{"GET /first HTTP/1.1\r\n\r\n", "GET /second HTTP/1.1\r\n\r\n"}),
+		ipFlow, portFlow, nil)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+	}
+	for _, c := range conversations {
+		if c.Request == nil || c.Response == nil {
+			t.Fatalf("Incomplete conversation %#v", c)
+		}
+		if "/"+string(c.ResponseBody) != c.Request.URL.Path {
+			t.Errorf("Response %q paired with %s", c.ResponseBody, c.Request.URL.Path)
+		}
+	}
+}
+
+// recordingConn keeps a copy of what's written so we have what would be
+// captured on the wire.
+type recordingConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (r *recordingConn) Write(b []byte) (int, error) {
+	r.buf.Write(b)
+	return r.Conn.Write(b)
+}
+
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// captureTLS runs req and res over a TLS connection, returning what would
+// have been captured for each side along with the key log.
+func captureTLS(t *testing.T, req, res string) (client, server string, keyLog *bytes.Buffer) {
+	t.Helper()
+	c, s := net.Pipe()
+	clientConn := &recordingConn{Conn: c}
+	serverConn := &recordingConn{Conn: s}
+	keyLog = &bytes.Buffer{}
+	go func() {
+		defer serverConn.Close()
+		conn := tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{selfSignedCertificate(t)},
+		})
+		if _, err := io.ReadFull(conn, make([]byte, len(req))); err == nil {
+			_, _ = conn.Write([]byte(res))
+		}
+	}()
+	conn := tls.Client(clientConn, &tls.Config{
+		//nolint:gosec
+		InsecureSkipVerify: true,
+		ServerName:         "example.test",
+		KeyLogWriter:       keyLog,
+	})
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, len(res))); err != nil {
+		t.Fatal(err)
+	}
+	clientConn.Close()
+	return clientConn.buf.String(), serverConn.buf.String(), keyLog
+}
+
+// readTLS reads both sides of a captured TLS connection.
+func readTLS(r *reader.HTTPConversationReaders, client, server string) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x01, 0xbb})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.ReadStream(newReader([]string{client}), ipFlow, portFlow, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		r.ReadStream(newReader([]string{server}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+	}()
+	wg.Wait()
+}
+
+func TestTLSStreamRead(t *testing.T) {
+	req := "GET /secret HTTP/1.1\r\nHost: example.test\r\n\r\n"
+	res := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}"
+	client, server, keyLog := captureTLS(t, req, res)
+
+	r := reader.New()
+	r.KeyLog = tlsdecode.NewKeyLog()
+	if err := r.KeyLog.Parse(keyLog); err != nil {
+		t.Fatal(err)
+	}
+	readTLS(r, client, server)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	got := conversations[0]
+	if got.Request == nil || got.Request.URL.Path != "/secret" {
+		t.Fatalf("Request not decrypted: %#v", got.Request)
+	}
+	if got.Request.TLS == nil || got.Request.TLS.ServerName != "example.test" ||
+		got.Request.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("Unexpected TLS state %#v", got.Request.TLS)
+	}
+	if string(got.ResponseBody) != "{}" {
+		t.Errorf("Unexpected response body %q", got.ResponseBody)
+	}
+	if got.TLS == nil || !got.TLSHandshake {
+		t.Errorf("Unexpected TLS info %#v", got.TLS)
+	}
+	if got.TLS != nil && got.TLS.Certificate == nil {
+		t.Error("Expected the decrypted certificate")
+	}
+}
+
+func TestTLSStreamReadWithoutKeys(t *testing.T) {
+	client, server, _ := captureTLS(t, "GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 204 No Content\r\n\r\n")
+
+	r := reader.New()
+	readTLS(r, client, server)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	got := conversations[0]
+	if got.Request != nil || got.Response != nil {
+		t.Errorf("Didn't expect to decrypt anything, got %#v", got)
+	}
+	if got.TLS == nil || got.TLS.ServerName != "example.test" || got.TLS.Version != tls.VersionTLS13 {
+		t.Fatalf("Unexpected TLS info %#v", got.TLS)
+	}
+	if len(got.RequestSeen) == 0 || len(got.ResponseSeen) == 0 {
+		t.Errorf("Expected times for the records, got %v and %v", got.RequestSeen, got.ResponseSeen)
+	}
+}
+
 func requestCompare(x, y http.Request) bool {
 	// FIXME: need to compare Header too
 	return x.Method == y.Method &&
@@ -177,3 +398,33 @@ func requestCompare(x, y http.Request) bool {
 func flowCompare(x, y gopacket.Flow) bool {
 	return x.String() == y.String()
 }
+
+func TestContentDecoding(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write([]byte(`{"name":"value"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	request := fmt.Sprintf("POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s",
+		compressed.Len(), compressed.String())
+	response := "HTTP/1.1 200 OK\r\nContent-Encoding: br\r\nContent-Length: 9\r\n\r\nnot brotl"
+
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+	r := reader.New()
+	r.ReadStream(newReader([]string{request}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{response}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	c := r.GetConversations()[0]
+	if string(c.RequestBody) != `{"name":"value"}` || c.RequestDecodeError != "" {
+		t.Errorf("Request body wasn't decoded: %q %s", c.RequestBody, c.RequestDecodeError)
+	}
+	// the body is kept as it was when it can't be decoded.
+	if string(c.ResponseBody) != "not brotl" || c.ResponseDecodeError == "" {
+		t.Errorf("Expected the raw response body with an error, got %q %q", c.ResponseBody, c.ResponseDecodeError)
+	}
+}
@@ -0,0 +1,183 @@
+package reader
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/colinnewell/pcap-cli/tcp"
+)
+
+// SkippedBytes is a part of a stream that couldn't be decoded, and was
+// skipped over to get back to something we could decode.
+type SkippedBytes struct {
+	// Offset from the start of the stream.
+	Offset int64
+	Length int64
+	// Seen is when the first of the bytes was seen.
+	Seen time.Time
+}
+
+const (
+	// longest request line we'll wait for when checking whether we've found
+	// the start of a request.
+	maxRequestLine = 8192
+	// length of a FastCGI record header plus the role from a begin request
+	// body.
+	fcgiStartLen = 10
+)
+
+//nolint:gochecknoglobals
+var requestMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("HEAD "), []byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "),
+	[]byte("TRACE "),
+}
+
+// streamPosition sits beneath the SavePointReader keeping track of how far
+// through the stream we are, and allowing bytes read while resynchronising
+// to be put back.
+type streamPosition struct {
+	r       io.Reader
+	pending []byte
+	// consumed is the number of bytes returned by Read, less those put
+	// back.
+	consumed int64
+}
+
+func (p *streamPosition) Read(b []byte) (int, error) {
+	if len(p.pending) > 0 {
+		n := copy(b, p.pending)
+		p.pending = p.pending[n:]
+		p.consumed += int64(n)
+		return n, nil
+	}
+	n, err := p.r.Read(b)
+	p.consumed += int64(n)
+	return n, err
+}
+
+// unread puts b back to be read again before the rest of the stream.
+func (p *streamPosition) unread(b []byte) {
+	p.pending = append(append([]byte{}, b...), p.pending...)
+	p.consumed -= int64(len(b))
+}
+
+// resync is used when none of the decoders understood the stream.  spr
+// should have been restored to the point they failed at.  It scans forward
+// for something that looks like the start of a request or response, leaving
+// the stream positioned there, and returns the bytes skipped over.  io.EOF
+// is returned if it got to the end of the stream without finding anything.
+func resync(spr *tcp.SavePointReader, pos *streamPosition) (SkippedBytes, error) {
+	readFromStream := func(b []byte) (int, bool, error) {
+		consumed := pos.consumed
+		n, err := spr.Read(b)
+		return n, pos.consumed != consumed, err
+	}
+
+	// the bytes the decoders tried are replayed first.  They need to be
+	// read in full so that anything put back comes before the rest of
+	// the stream.
+	var buf []byte
+	chunk := make([]byte, 4096)
+	var err error
+	for err == nil {
+		var n int
+		var fromStream bool
+		n, fromStream, err = readFromStream(chunk)
+		buf = append(buf, chunk[:n]...)
+		if fromStream {
+			break
+		}
+	}
+	skipped := SkippedBytes{Offset: pos.consumed - int64(len(buf))}
+
+	// the decoders have already failed at the very start.
+	i := 1
+	for {
+		for ; i < len(buf); i++ {
+			found, needMore := plausibleStart(buf[i:], err != nil)
+			if needMore {
+				break
+			}
+			if found {
+				pos.unread(buf[i:])
+				spr.SavePoint()
+				skipped.Length += int64(i)
+				return skipped, nil
+			}
+		}
+		if err != nil {
+			skipped.Length += int64(len(buf))
+			return skipped, io.EOF
+		}
+		// drop what we've ruled out.
+		skipped.Length += int64(i)
+		buf = buf[i:]
+		i = 0
+		var n int
+		n, err = spr.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+	}
+}
+
+// plausibleStart checks whether b looks like the start of an HTTP request
+// or response, or a FastCGI record that starts one.  needMore is returned
+// when we can't tell without seeing more of the stream.
+func plausibleStart(b []byte, eof bool) (found, needMore bool) {
+	if len(b) < len("HTTP/1.1 200") && !eof {
+		return false, true
+	}
+	if bytes.HasPrefix(b, []byte("HTTP/1.")) {
+		return len(b) >= 12 && (b[7] == '0' || b[7] == '1') && b[8] == ' ' &&
+			isDigit(b[9]) && isDigit(b[10]) && isDigit(b[11]), false
+	}
+	for _, method := range requestMethods {
+		if bytes.HasPrefix(b, method) {
+			line := b
+			end := bytes.IndexByte(line, '\n')
+			if end < 0 {
+				return false, !eof && len(b) < maxRequestLine
+			}
+			line = bytes.TrimSuffix(line[:end], []byte("\r"))
+			return bytes.HasSuffix(line, []byte(" HTTP/1.0")) ||
+				bytes.HasSuffix(line, []byte(" HTTP/1.1")), false
+		}
+	}
+	return plausibleFCGIStart(b, eof)
+}
+
+// plausibleFCGIStart checks for the record that starts a FastCGI request,
+// or the first output of a response.
+func plausibleFCGIStart(b []byte, eof bool) (found, needMore bool) {
+	const (
+		version      = 1
+		beginRequest = 1
+		stdout       = 6
+		stderr       = 7
+		responder    = 1
+	)
+	if b[0] != version {
+		return false, false
+	}
+	if len(b) < fcgiStartLen {
+		return false, !eof
+	}
+	id := int(b[2])<<8 | int(b[3])
+	length := int(b[4])<<8 | int(b[5])
+	if id == 0 || b[7] != 0 {
+		return false, false
+	}
+	switch b[1] {
+	case beginRequest:
+		role := int(b[8])<<8 | int(b[9])
+		return length == 8 && role == responder, false
+	case stdout, stderr:
+		return length > 0, false
+	}
+	return false, false
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
@@ -0,0 +1,50 @@
+package reader_test
+
+import (
+	"testing"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestResync(t *testing.T) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+
+	first := "GET /first HTTP/1.1\r\n\r\n"
+	garbage := "\x00\x17garbage where a segment went missing GET /not-a-request\r\n"
+	r := reader.New()
+	r.ReadStream(newReader([]string{
+		first,
+		garbage,
+		"GET /second HTTP/1.1\r\n\r\n",
+		"more garbage",
+	}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+	}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+	}
+	var paths []string
+	for _, c := range conversations {
+		if c.Request == nil || c.Response == nil {
+			t.Fatalf("Incomplete conversation %#v", c)
+		}
+		paths = append(paths, c.Request.URL.Path)
+	}
+	if diff := cmp.Diff(paths, []string{"/first", "/second"}); diff != "" {
+		t.Errorf("Requests don't match (-got +expected):\n%s\n", diff)
+	}
+	if diff := cmp.Diff(conversations[1].RequestSkipped, []reader.SkippedBytes{
+		{Offset: int64(len(first)), Length: int64(len(garbage))},
+		{Offset: int64(len(first) + len(garbage) + len("GET /second HTTP/1.1\r\n\r\n")), Length: 12},
+	}); diff != "" {
+		t.Errorf("Skipped bytes don't match (-got +expected):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,29 @@
+package reader
+
+import "io"
+
+// MessageSize has the sizes of a request or response as it was sent.
+type MessageSize struct {
+	// Body is the size of the body with any chunked framing removed, but
+	// before it's decompressed.
+	Body int64
+	// Transfer is the total size on the wire, including the headers and
+	// any framing.  It's 0 when we don't know it.
+	Transfer int64
+	// Header is the size of the headers on the wire when there isn't a raw
+	// header block, like the payload of an HTTP/2 HEADERS frame.  It's 0
+	// when we don't know it.
+	Header int64
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
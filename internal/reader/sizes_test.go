@@ -0,0 +1,51 @@
+package reader_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+)
+
+func TestMessageSizes(t *testing.T) {
+	content := strings.Repeat("hello ", 100)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	response := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Encoding: gzip\r\n\r\n" +
+		fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", compressed.Len(), compressed.String())
+	request := "POST / HTTP/1.1\r\nHost: example.test\r\nContent-Length: 4\r\n\r\ndata"
+
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0xc3, 0x50}, []byte{0x1f, 0x90})
+	r := reader.New()
+	r.ReadStream(newReader([]string{request}), ipFlow, portFlow, nil)
+	r.ReadStream(newReader([]string{response}), ipFlow.Reverse(), portFlow.Reverse(), nil)
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	c := conversations[0]
+	if string(c.ResponseBody) != content {
+		t.Errorf("Response body wasn't decompressed: %q", c.ResponseBody)
+	}
+	if diff := cmp.Diff(c.RequestSize, reader.MessageSize{Body: 4, Transfer: int64(len(request))}); diff != "" {
+		t.Errorf("Request size doesn't match (-got +expected):\n%s\n", diff)
+	}
+	expected := reader.MessageSize{Body: int64(compressed.Len()), Transfer: int64(len(response))}
+	if diff := cmp.Diff(c.ResponseSize, expected); diff != "" {
+		t.Errorf("Response size doesn't match (-got +expected):\n%s\n", diff)
+	}
+}
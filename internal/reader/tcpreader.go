@@ -40,6 +40,8 @@ type TCPReaderStream struct {
 	lossReported bool
 	first        bool
 	initiated    bool
+	// waiting is told when Read blocks waiting for more data.
+	waiting func(bool)
 	ReaderStreamOptions
 }
 
@@ -101,6 +103,9 @@ func (r *TCPReaderStream) Read(p []byte) (int, error) {
 	var ok bool
 	r.stripEmpty()
 	for !r.closed && len(r.current) == 0 {
+		if r.waiting != nil {
+			r.waiting(true)
+		}
 		if r.first {
 			r.first = false
 		} else {
@@ -111,6 +116,9 @@ func (r *TCPReaderStream) Read(p []byte) (int, error) {
 		} else {
 			r.closed = true
 		}
+		if r.waiting != nil {
+			r.waiting(false)
+		}
 	}
 	if len(r.current) > 0 {
 		current := &r.current[0]
@@ -141,6 +149,16 @@ func (r *TCPReaderStream) Seen() (time.Time, error) {
 	return time.Time{}, io.EOF
 }
 
+// Skip returns the number of bytes lost ahead of the data about to be read,
+// as reported by a DataLost error.  It's -1 when the number isn't known,
+// which happens when the start of the stream is missing.
+func (r *TCPReaderStream) Skip() int {
+	if len(r.current) > 0 {
+		return r.current[0].Skip
+	}
+	return 0
+}
+
 // Close implements io.Closer's Close function, making TCPReaderStream a
 // io.ReadCloser.  It discards all remaining bytes in the reassembly in a
 // manner that's safe for the assembler (IE: it doesn't block).
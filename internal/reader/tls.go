@@ -0,0 +1,234 @@
+package reader
+
+import (
+	"crypto/tls"
+	"io"
+	"time"
+
+	"github.com/colinnewell/pcap-cli/tcp"
+	"github.com/google/gopacket"
+
+	"github.com/colinnewell/pcap2har-go/internal/tlsdecode"
+)
+
+// streamState tracks the tcp streams we've been given so that the two sides
+// of a TLS connection can tell when the other side isn't going to turn up.
+type streamState struct {
+	opened, closed bool
+	// messages is the number of requests or responses read from the
+	// stream, which is the position of the next one on the connection.
+	messages int
+	// client is set once a request has been read from the stream.
+	client bool
+	// skipped has the bytes skipped since the last request or response.
+	skipped []SkippedBytes
+	// incomplete is set when data has been lost from the capture since the
+	// last request or response, along with the number of bytes when we
+	// know it.
+	incomplete   bool
+	bytesMissing int64
+	// waiting is set while the reader can't get any further until more of
+	// the capture is assembled, or until the other side has been read.
+	waiting bool
+	// upgrading is set when the last request asked to upgrade to a
+	// WebSocket, with upgrade its position.
+	upgrading bool
+	upgrade   int
+}
+
+// prefixStream replays the bytes peeked from the start of a stream before
+// carrying on reading the stream itself.
+type prefixStream struct {
+	prefix     []byte
+	seen       time.Time
+	fromPrefix bool
+	r          tcp.Stream
+}
+
+func (p *prefixStream) Read(b []byte) (int, error) {
+	if len(p.prefix) > 0 {
+		n := copy(b, p.prefix)
+		p.prefix = p.prefix[n:]
+		p.fromPrefix = true
+		if len(p.prefix) > 0 || n == len(b) {
+			return n, nil
+		}
+		// carry on with the rest of the packet the prefix came from so
+		// that the read is still seen as a single packet.
+		m, _ := p.r.Read(b[n:])
+		if m > 0 {
+			p.fromPrefix = false
+		}
+		return n + m, nil
+	}
+	p.fromPrefix = false
+	return p.r.Read(b)
+}
+
+func (p *prefixStream) Seen() (time.Time, error) {
+	if p.fromPrefix && !p.seen.IsZero() {
+		return p.seen, nil
+	}
+	return p.r.Seen()
+}
+
+// tlsStream checks whether the stream starts with a TLS handshake, and if it
+// does returns a stream that decrypts it.  Without a KeyLog nothing is
+// decrypted, but the handshake is still read.
+func (h *HTTPConversationReaders) tlsStream(r tcp.Stream, address ConversationAddress) tcp.Stream {
+	start := make([]byte, 6)
+	n, _ := io.ReadFull(r, start)
+	seen, _ := r.Seen()
+	s := &prefixStream{prefix: start[:n], seen: seen, r: r}
+	if ok, client := tlsdecode.Sniff(start[:n]); ok {
+		return tlsdecode.NewReader(h.tlsConn(address, client), s, client)
+	}
+	return s
+}
+
+// tlsConn returns the TLS state shared by both sides of the connection.
+func (h *HTTPConversationReaders) tlsConn(address ConversationAddress, client bool) *tlsdecode.Conn {
+	if !client {
+		address = address.reverse()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.tlsConns[address]
+	if !ok {
+		c = tlsdecode.NewConn(h.KeyLog)
+		h.tlsConns[address] = c
+		h.markFinishedSides(address, c)
+	}
+	return c
+}
+
+// addTLSInfo adds the handshake details to the conversations on the
+// connection from address if it's TLS.  A connection we couldn't decrypt gets
+// a conversation of its own so that it still shows up.  h.mu must be held.
+func (h *HTTPConversationReaders) addTLSInfo(address ConversationAddress) {
+	c, ok := h.tlsConns[address]
+	if !ok {
+		return
+	}
+	info := c.Info()
+	if info == nil {
+		return
+	}
+	conversations := h.conversations[address]
+	if len(conversations) == 0 {
+		seen := info.ClientSeen
+		if len(seen) == 0 {
+			// the handshake didn't get as far as the client finishing it.
+			if info.Started.IsZero() {
+				return
+			}
+			seen = []time.Time{info.Started}
+		}
+		conversations = []Conversation{{
+			Address:      address,
+			RequestSeen:  seen,
+			ResponseSeen: info.ServerSeen,
+		}}
+		h.conversations[address] = conversations
+	}
+	for n := range conversations {
+		conversations[n].TLS = info
+	}
+	conversations[0].TLSHandshake = true
+}
+
+// tlsState returns the negotiated TLS details for the connection from the
+// client address, if it was TLS.  h.mu must be held.
+func (h *HTTPConversationReaders) tlsState(address ConversationAddress) *tls.ConnectionState {
+	if c, ok := h.tlsConns[address]; ok {
+		return c.ConnectionState()
+	}
+	return nil
+}
+
+// streamOpened registers a new stream, returning its address.  It needs to
+// be called as the stream is created so that the address picks up the ISN
+// from the handshake that started it, rather than a later one reusing the
+// ports.
+func (h *HTTPConversationReaders) streamOpened(a, b gopacket.Flow) ConversationAddress {
+	address := ConversationAddress{IP: a, Port: b}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if isn, ok := h.isns[address]; ok {
+		address.ISN = isn
+	} else if isn, ok := h.isns[address.reverse()]; ok {
+		// the server side of the connection.
+		address.ISN = isn
+	}
+	if other, ok := h.streams[address.reverse()]; !ok || !other.opened {
+		// the first side of the connection to turn up.
+		h.claimLookup(address)
+	}
+	h.stream(address).opened = true
+	return address
+}
+
+func (h *HTTPConversationReaders) streamClosed(address ConversationAddress) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stream(address)
+	s.closed = true
+	h.cond.Broadcast()
+	if (len(s.skipped) > 0 || s.incomplete) && s.messages > 0 {
+		if s.client {
+			c := h.conversation(address, s.messages-1)
+			c.RequestSkipped = append(c.RequestSkipped, s.skipped...)
+			markIncomplete(c, s, "request")
+		} else {
+			c := h.conversation(address.reverse(), s.messages-1)
+			c.ResponseSkipped = append(c.ResponseSkipped, s.skipped...)
+			markIncomplete(c, s, "response")
+		}
+		s.skipped = nil
+	}
+	if c, ok := h.tlsConns[address]; ok {
+		c.Done(true)
+	}
+	if c, ok := h.tlsConns[address.reverse()]; ok {
+		c.Done(false)
+	}
+}
+
+// assemblyComplete is called once all the packets have been passed to the
+// assembler so no new streams will be turning up.
+func (h *HTTPConversationReaders) assemblyComplete() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.assembled = true
+	for address, c := range h.tlsConns {
+		h.markFinishedSides(address, c)
+	}
+}
+
+// markFinishedSides tells the TLS connection about any side that has already
+// finished, or that we now know was never captured.  h.mu must be held.
+func (h *HTTPConversationReaders) markFinishedSides(address ConversationAddress, c *tlsdecode.Conn) {
+	sides := []struct {
+		address ConversationAddress
+		client  bool
+	}{
+		{address, true},
+		{address.reverse(), false},
+	}
+	for _, side := range sides {
+		s, ok := h.streams[side.address]
+		if (ok && s.closed) || (h.assembled && (!ok || !s.opened)) {
+			c.Done(side.client)
+		}
+	}
+}
+
+// stream returns the state for the stream, h.mu must be held.
+func (h *HTTPConversationReaders) stream(address ConversationAddress) *streamState {
+	s, ok := h.streams[address]
+	if !ok {
+		s = &streamState{}
+		h.streams[address] = s
+	}
+	return s
+}
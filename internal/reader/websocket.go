@@ -0,0 +1,253 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/colinnewell/pcap-cli/tcp"
+	"github.com/google/gopacket/tcpassembly/tcpreader"
+)
+
+// WebSocket opcodes, https://www.rfc-editor.org/rfc/rfc6455#section-5.2
+const (
+	opContinuation = 0x0
+	opClose        = 0x8
+)
+
+var errInvalidFrame = errors.New("websocket: invalid frame")
+
+// maximum window size for permessage-deflate.
+const deflateWindow = 1 << 15
+
+// WebSocketMessage is a message sent over a WebSocket after the connection was
+// upgraded.
+type WebSocketMessage struct {
+	// Sent is true for messages from the client, false for those received
+	// from the server.
+	Sent   bool
+	Time   time.Time
+	Opcode int
+	Data   []byte
+}
+
+var errNotWebSocket = errors.New("not a WebSocket")
+
+func isWebSocketUpgrade(header http.Header) bool {
+	return strings.EqualFold(header.Get("Upgrade"), "websocket")
+}
+
+// webSocketReader reassembles the messages from one direction of a
+// WebSocket.
+type webSocketReader struct {
+	r      io.Reader
+	t      *tcp.TimeCaptureReader
+	client bool
+	// the fragments of the message being read.
+	opcode     int
+	compressed bool
+	data       []byte
+	// window is the end of the uncompressed data so far, which compressed
+	// messages can refer back to unless context takeover is disabled.
+	window []byte
+}
+
+// ReadWebSocket reads the frames from a client that asked to upgrade to a
+// WebSocket, once the server has agreed.  The client has to wait for the
+// response before sending anything else, so when more arrives the response
+// should have been read.  Until then we can't tell whether the upgrade was
+// rejected and the client carried on with HTTP.
+func (h *HTTPConversationReaders) ReadWebSocket(spr *tcp.SavePointReader, t *tcp.TimeCaptureReader, address ConversationAddress) error {
+	n, ok := h.pendingUpgrade(address)
+	if !ok {
+		return errNotWebSocket
+	}
+	buf := bufio.NewReader(spr)
+	if _, err := buf.Peek(1); err != nil {
+		return err
+	}
+	if !h.upgraded(address, n) {
+		return errNotWebSocket
+	}
+	h.readWebSocket(buf, t, address, true)
+	return io.EOF
+}
+
+// requestUpgrade notes that the nth request from the client at address asked
+// to upgrade to a WebSocket.
+func (h *HTTPConversationReaders) requestUpgrade(address ConversationAddress, n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stream(address)
+	s.upgrading = true
+	s.upgrade = n
+}
+
+// pendingUpgrade returns the position of the request from the client at
+// address waiting to find out if its upgrade was accepted.
+func (h *HTTPConversationReaders) pendingUpgrade(address ConversationAddress) (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stream(address)
+	if !s.upgrading {
+		return 0, false
+	}
+	s.upgrading = false
+	return s.upgrade, true
+}
+
+// readWebSocket reads frames until the end of the stream, adding the messages
+// to the conversation that did the upgrade.
+func (h *HTTPConversationReaders) readWebSocket(r io.Reader, t *tcp.TimeCaptureReader, address ConversationAddress, client bool) {
+	if !client {
+		address = address.reverse()
+	}
+	ws := webSocketReader{r: r, t: t, client: client}
+	t.Reset()
+	for {
+		m, err := ws.message()
+		if err != nil {
+			if err != io.EOF {
+				tcpreader.DiscardBytesToEOF(r)
+			}
+			return
+		}
+		h.addWebSocketMessage(address, m)
+	}
+}
+
+func (h *HTTPConversationReaders) addWebSocketMessage(address ConversationAddress, m WebSocketMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conversations := h.conversations[address]
+	if len(conversations) == 0 {
+		return
+	}
+	// the upgrade has to be the last thing on the connection.
+	c := &conversations[len(conversations)-1]
+	c.WebSocketMessages = append(c.WebSocketMessages, m)
+}
+
+// message reads the frames for the next message.  Control frames can come in
+// the middle of a fragmented message so they're returned as soon as they're
+// read, leaving the fragments to be picked up on the next call.
+func (ws *webSocketReader) message() (WebSocketMessage, error) {
+	for {
+		fin, rsv1, op, payload, err := ws.frame()
+		if err != nil {
+			return WebSocketMessage{}, err
+		}
+		if op >= opClose {
+			return ws.complete(op, false, payload), nil
+		}
+		if op != opContinuation {
+			ws.opcode = op
+			ws.compressed = rsv1
+			ws.data = nil
+		}
+		ws.data = append(ws.data, payload...)
+		if fin {
+			m := ws.complete(ws.opcode, ws.compressed, ws.data)
+			ws.data = nil
+			return m, nil
+		}
+	}
+}
+
+func (ws *webSocketReader) complete(opcode int, compressed bool, data []byte) WebSocketMessage {
+	m := WebSocketMessage{Sent: ws.client, Opcode: opcode, Data: data}
+	if seen := ws.t.Seen(); len(seen) > 0 {
+		m.Time = seen[len(seen)-1]
+	}
+	ws.t.Reset()
+	if compressed {
+		if inflated, err := ws.inflate(data); err == nil {
+			m.Data = inflated
+		}
+	}
+	return m
+}
+
+// inflate decompresses a permessage-deflate message,
+// https://www.rfc-editor.org/rfc/rfc7692#section-7.2.2
+func (ws *webSocketReader) inflate(data []byte) ([]byte, error) {
+	// the sender strips the empty block from the end of the message, put
+	// it back along with a final block so the reader knows it's the end.
+	in := io.MultiReader(bytes.NewReader(data),
+		bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}))
+	// feeding in the previous output as the dictionary deals with context
+	// takeover, and doesn't matter when it's not in use.
+	fr := flate.NewReaderDict(in, ws.window)
+	defer fr.Close()
+	out, err := io.ReadAll(fr)
+	if err != nil {
+		return nil, err
+	}
+	ws.window = append(ws.window, out...)
+	if len(ws.window) > deflateWindow {
+		ws.window = ws.window[len(ws.window)-deflateWindow:]
+	}
+	return out, nil
+}
+
+// frame reads a single frame, unmasking the payload.
+func (ws *webSocketReader) frame() (fin, rsv1 bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(ws.r, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	rsv1 = header[0]&0x40 != 0
+	opcode = int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var l [2]byte
+		if _, err = io.ReadFull(ws.r, l[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		if _, err = io.ReadFull(ws.r, l[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(l[:])
+	}
+
+	if length > math.MaxInt64 {
+		err = errInvalidFrame
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(ws.r, mask[:]); err != nil {
+			return
+		}
+	}
+	// don't trust the length enough to allocate it all up front.
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, ws.r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	payload = buf.Bytes()
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
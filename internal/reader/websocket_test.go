@@ -0,0 +1,219 @@
+package reader_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/reader"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket"
+)
+
+// wsFrame builds a WebSocket frame, masking it when a key is given as
+// clients have to.
+func wsFrame(first byte, payload []byte, mask []byte) []byte {
+	frame := []byte{first}
+	lengthByte := func(b byte) byte {
+		if mask != nil {
+			return b | 0x80
+		}
+		return b
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, lengthByte(byte(len(payload))))
+	default:
+		frame = append(frame, lengthByte(126), byte(len(payload)>>8), byte(len(payload)))
+	}
+	if mask == nil {
+		return append(frame, payload...)
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// packetReader returns the packets sent to it, one at a time.
+type packetReader struct {
+	packets chan string
+	current string
+}
+
+func (r *packetReader) Read(p []byte) (int, error) {
+	for r.current == "" {
+		packet, ok := <-r.packets
+		if !ok {
+			return 0, io.EOF
+		}
+		r.current = packet
+	}
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+func (r *packetReader) Seen() (time.Time, error) {
+	return time.Time{}, nil
+}
+
+type packet struct {
+	fromClient bool
+	data       string
+}
+
+// readPackets reads both sides of a connection at the same time, passing on
+// the packets in order.  Like the assembler each packet is only taken once
+// the reader is done with the one before it.
+func readPackets(r *reader.HTTPConversationReaders, packets []packet) {
+	ipFlow := gopacket.NewFlow(1, []byte{0x7f, 0x0, 0x0, 0x1}, []byte{0x7f,
+		0x0, 0x0, 0x1})
+	portFlow := gopacket.NewFlow(4, []byte{0x23, 0x36}, []byte{0x1f, 0x90})
+	client := &packetReader{packets: make(chan string)}
+	server := &packetReader{packets: make(chan string)}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.ReadStream(client, ipFlow, portFlow, nil)
+	}()
+	// the client side of a connection is always seen first.
+	client.packets <- packets[0].data
+	go func() {
+		defer wg.Done()
+		r.ReadStream(server, ipFlow.Reverse(), portFlow.Reverse(), nil)
+	}()
+	for _, p := range packets[1:] {
+		if p.fromClient {
+			client.packets <- p.data
+		} else {
+			server.packets <- p.data
+		}
+	}
+	close(client.packets)
+	close(server.packets)
+	wg.Wait()
+}
+
+// deflater compresses messages the way permessage-deflate does, keeping the
+// context between messages.
+type deflater struct {
+	buf bytes.Buffer
+	w   *flate.Writer
+}
+
+func (d *deflater) compress(t *testing.T, message string) []byte {
+	t.Helper()
+	if d.w == nil {
+		var err error
+		if d.w, err = flate.NewWriter(&d.buf, flate.BestCompression); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.buf.Reset()
+	if _, err := d.w.Write([]byte(message)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.TrimSuffix(d.buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+}
+
+func TestWebSocketRead(t *testing.T) {
+	mask := []byte{1, 2, 3, 4}
+	var clientDeflate, serverDeflate deflater
+
+	client := "GET /chat HTTP/1.1\r\nHost: example.test\r\nUpgrade: websocket\r\n" +
+		"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Extensions: permessage-deflate\r\n\r\n"
+	var clientFrames []byte
+	clientFrames = append(clientFrames, wsFrame(0x81, []byte("hello"), mask)...)
+	// a fragmented message with a ping in the middle.
+	clientFrames = append(clientFrames, wsFrame(0x01, []byte("frag"), mask)...)
+	clientFrames = append(clientFrames, wsFrame(0x89, []byte("ping"), mask)...)
+	clientFrames = append(clientFrames, wsFrame(0x80, []byte("mented"), mask)...)
+	clientFrames = append(clientFrames, wsFrame(0xc1, clientDeflate.compress(t, "compressed compressed"), mask)...)
+	// the second message refers back to the first.
+	clientFrames = append(clientFrames, wsFrame(0xc1, clientDeflate.compress(t, "compressed again"), mask)...)
+
+	server := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n\r\n"
+	var serverFrames []byte
+	serverFrames = append(serverFrames, wsFrame(0x82, []byte{0, 1, 2}, nil)...)
+	serverFrames = append(serverFrames, wsFrame(0xc1, serverDeflate.compress(t, "hello yourself"), nil)...)
+	serverFrames = append(serverFrames, wsFrame(0x88, []byte{0x03, 0xe8}, nil)...)
+
+	r := reader.New()
+	// the client has to wait for the response before sending any frames.
+	readPackets(r, []packet{
+		{fromClient: true, data: client},
+		{data: server},
+		{data: string(serverFrames)},
+		{fromClient: true, data: string(clientFrames)},
+	})
+
+	conversations := r.GetConversations()
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+	}
+	expected := []reader.WebSocketMessage{
+		{Sent: true, Opcode: 1, Data: []byte("hello")},
+		{Sent: true, Opcode: 9, Data: []byte("ping")},
+		{Sent: true, Opcode: 1, Data: []byte("fragmented")},
+		{Sent: true, Opcode: 1, Data: []byte("compressed compressed")},
+		{Sent: true, Opcode: 1, Data: []byte("compressed again")},
+		{Opcode: 2, Data: []byte{0, 1, 2}},
+		{Opcode: 1, Data: []byte("hello yourself")},
+		{Opcode: 8, Data: []byte{0x03, 0xe8}},
+	}
+	// the two sides are read at the same time so only the order in each
+	// direction is fixed.
+	messages := conversations[0].WebSocketMessages
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Sent && !messages[j].Sent
+	})
+	if diff := cmp.Diff(messages, expected,
+		cmpopts.IgnoreFields(reader.WebSocketMessage{}, "Time"),
+	); diff != "" {
+		t.Errorf("Messages don't match (-got +expected):\n%s\n", diff)
+	}
+}
+
+func TestWebSocketRejected(t *testing.T) {
+	client := "GET /chat HTTP/1.1\r\nHost: example.test\r\nUpgrade: websocket\r\n" +
+		"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+
+	r := reader.New()
+	// the client carries on with HTTP after the upgrade is turned down.
+	readPackets(r, []packet{
+		{fromClient: true, data: client},
+		{data: "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"},
+		{fromClient: true, data: "GET /next HTTP/1.1\r\nHost: example.test\r\n\r\n"},
+		{data: "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"},
+	})
+
+	conversations := r.GetConversations()
+	if len(conversations) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+	}
+	next := conversations[1]
+	if next.Request.URL.Path != "/next" || string(next.ResponseBody) != "ok" {
+		t.Errorf("Expected the next request to be read as HTTP, got %s %q",
+			next.Request.URL.Path, next.ResponseBody)
+	}
+	for _, c := range conversations {
+		if len(c.WebSocketMessages) > 0 {
+			t.Errorf("Unexpected WebSocket messages %v", c.WebSocketMessages)
+		}
+	}
+}
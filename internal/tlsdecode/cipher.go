@@ -0,0 +1,289 @@
+package tlsdecode
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	// the hashes need registering for crypto.Hash.New to work.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// cipherSuite describes what we need to know about a cipher suite in order to
+// decrypt records that use it.
+type cipherSuite struct {
+	// hash used for the PRF (TLS 1.2) or HKDF (TLS 1.3).
+	hash   crypto.Hash
+	keyLen int
+	// fixed IV length taken from the key block or traffic secret.
+	ivLen int
+	// macLen is only non zero for CBC suites.
+	macLen int
+	// aead is nil for CBC suites.
+	aead func(key []byte) (cipher.AEAD, error)
+	// explicitNonce is set for the TLS 1.2 AES-GCM suites which send part
+	// of the nonce with each record.
+	explicitNonce bool
+}
+
+func aesGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//nolint:gochecknoglobals
+var (
+	suiteAES128GCM = &cipherSuite{hash: crypto.SHA256, keyLen: 16, ivLen: 4, aead: aesGCM, explicitNonce: true}
+	suiteAES256GCM = &cipherSuite{hash: crypto.SHA384, keyLen: 32, ivLen: 4, aead: aesGCM, explicitNonce: true}
+	suiteChaCha    = &cipherSuite{hash: crypto.SHA256, keyLen: 32, ivLen: 12, aead: chacha20poly1305.New}
+	suiteAES128SHA = &cipherSuite{hash: crypto.SHA256, keyLen: 16, ivLen: 16, macLen: 20}
+	suiteAES256SHA = &cipherSuite{hash: crypto.SHA256, keyLen: 32, ivLen: 16, macLen: 20}
+	suiteAES128256 = &cipherSuite{hash: crypto.SHA256, keyLen: 16, ivLen: 16, macLen: 32}
+
+	cipherSuites = map[uint16]*cipherSuite{
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256:               suiteAES128GCM,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384:               suiteAES256GCM,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256:       suiteAES128GCM,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384:       suiteAES256GCM,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:         suiteAES128GCM,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:         suiteAES256GCM,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256:   suiteChaCha,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256: suiteChaCha,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA:                  suiteAES128SHA,
+		tls.TLS_RSA_WITH_AES_256_CBC_SHA:                  suiteAES256SHA,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:          suiteAES128SHA,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:          suiteAES256SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:            suiteAES128SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:            suiteAES256SHA,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA256:               suiteAES128256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256:       suiteAES128256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:         suiteAES128256,
+
+		// TLS 1.3 suites always derive a 12 byte IV from the traffic secret.
+		tls.TLS_AES_128_GCM_SHA256:       {hash: crypto.SHA256, keyLen: 16, ivLen: 12, aead: aesGCM},
+		tls.TLS_AES_256_GCM_SHA384:       {hash: crypto.SHA384, keyLen: 32, ivLen: 12, aead: aesGCM},
+		tls.TLS_CHACHA20_POLY1305_SHA256: {hash: crypto.SHA256, keyLen: 32, ivLen: 12, aead: chacha20poly1305.New},
+	}
+)
+
+var errDecrypt = errors.New("tls: unable to decrypt record")
+
+// halfConn holds the keys for one direction of a connection.
+type halfConn struct {
+	version        uint16
+	suite          *cipherSuite
+	aead           cipher.AEAD
+	block          cipher.Block
+	iv             []byte
+	seq            uint64
+	encryptThenMAC bool
+	// TLS 1.3 traffic secret, kept so that we can follow key updates.
+	secret []byte
+}
+
+// newHalfConn12 derives the keys for one side of a TLS 1.2 connection from
+// the master secret.
+func newHalfConn12(suite *cipherSuite, masterSecret, clientRandom, serverRandom []byte, client bool) (*halfConn, error) {
+	seed := make([]byte, 0, len(serverRandom)+len(clientRandom))
+	seed = append(seed, serverRandom...)
+	seed = append(seed, clientRandom...)
+	n := 2*suite.macLen + 2*suite.keyLen + 2*suite.ivLen
+	keyBlock := prf12(suite.hash.New, masterSecret, "key expansion", seed, n)
+
+	// the key block is laid out as client mac, server mac, client key,
+	// server key, client iv, server iv.
+	keys := keyBlock[2*suite.macLen:]
+	ivs := keys[2*suite.keyLen:]
+	key, iv := keys[:suite.keyLen], ivs[:suite.ivLen]
+	if !client {
+		key, iv = keys[suite.keyLen:2*suite.keyLen], ivs[suite.ivLen:2*suite.ivLen]
+	}
+
+	hc := &halfConn{version: tls.VersionTLS12, suite: suite, iv: iv}
+	return hc, hc.setKey(key)
+}
+
+// newHalfConn13 derives the keys for one side of a TLS 1.3 connection from a
+// traffic secret.
+func newHalfConn13(suite *cipherSuite, secret []byte) (*halfConn, error) {
+	hc := &halfConn{version: tls.VersionTLS13, suite: suite}
+	return hc, hc.setTrafficSecret(secret)
+}
+
+func (hc *halfConn) setTrafficSecret(secret []byte) error {
+	hc.secret = secret
+	hc.iv = expandLabel(hc.suite.hash, secret, "iv", hc.suite.ivLen)
+	hc.seq = 0
+	return hc.setKey(expandLabel(hc.suite.hash, secret, "key", hc.suite.keyLen))
+}
+
+// updateKeys follows a TLS 1.3 KeyUpdate.
+func (hc *halfConn) updateKeys() error {
+	return hc.setTrafficSecret(expandLabel(hc.suite.hash, hc.secret, "traffic upd", hc.suite.hash.Size()))
+}
+
+func (hc *halfConn) setKey(key []byte) error {
+	var err error
+	if hc.suite.aead != nil {
+		hc.aead, err = hc.suite.aead(key)
+	} else {
+		hc.block, err = aes.NewCipher(key)
+	}
+	return err
+}
+
+// decrypt the payload of a record returning the real content type and the
+// plaintext.
+func (hc *halfConn) decrypt(rec *record) (uint8, []byte, error) {
+	seq := hc.seq
+	hc.seq++
+	if hc.version == tls.VersionTLS13 {
+		return hc.decrypt13(rec, seq)
+	}
+	if hc.aead != nil {
+		plaintext, err := hc.decryptAEAD12(rec, seq)
+		return rec.typ, plaintext, err
+	}
+	plaintext, err := hc.decryptCBC(rec)
+	return rec.typ, plaintext, err
+}
+
+func (hc *halfConn) nonce(seq uint64) []byte {
+	nonce := make([]byte, len(hc.iv))
+	copy(nonce, hc.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+	}
+	return nonce
+}
+
+func (hc *halfConn) decrypt13(rec *record, seq uint64) (uint8, []byte, error) {
+	plaintext, err := hc.aead.Open(nil, hc.nonce(seq), rec.payload, rec.header())
+	if err != nil {
+		return 0, nil, errDecrypt
+	}
+	// strip the padding to find the real content type.
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, errDecrypt
+	}
+	return plaintext[i], plaintext[:i], nil
+}
+
+func (hc *halfConn) decryptAEAD12(rec *record, seq uint64) ([]byte, error) {
+	payload := rec.payload
+	var nonce []byte
+	if hc.suite.explicitNonce {
+		if len(payload) < 8 {
+			return nil, errDecrypt
+		}
+		nonce = append(append([]byte{}, hc.iv...), payload[:8]...)
+		payload = payload[8:]
+	} else {
+		nonce = hc.nonce(seq)
+	}
+	if len(payload) < hc.aead.Overhead() {
+		return nil, errDecrypt
+	}
+	ad := additionalData(seq, rec, len(payload)-hc.aead.Overhead())
+	plaintext, err := hc.aead.Open(nil, nonce, payload, ad)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return plaintext, nil
+}
+
+// decryptCBC decrypts a TLS 1.2 CBC record.  We aren't trying to protect
+// ourselves from anything so the MAC is simply discarded rather than checked.
+func (hc *halfConn) decryptCBC(rec *record) ([]byte, error) {
+	payload := rec.payload
+	if hc.encryptThenMAC {
+		if len(payload) < hc.suite.macLen {
+			return nil, errDecrypt
+		}
+		payload = payload[:len(payload)-hc.suite.macLen]
+	}
+	blockSize := hc.block.BlockSize()
+	if len(payload) < 2*blockSize || len(payload)%blockSize != 0 {
+		return nil, errDecrypt
+	}
+	plaintext := make([]byte, len(payload)-blockSize)
+	cipher.NewCBCDecrypter(hc.block, payload[:blockSize]).CryptBlocks(plaintext, payload[blockSize:])
+
+	padding := int(plaintext[len(plaintext)-1]) + 1
+	if padding > len(plaintext) {
+		return nil, errDecrypt
+	}
+	plaintext = plaintext[:len(plaintext)-padding]
+	if !hc.encryptThenMAC {
+		if len(plaintext) < hc.suite.macLen {
+			return nil, errDecrypt
+		}
+		plaintext = plaintext[:len(plaintext)-hc.suite.macLen]
+	}
+	return plaintext, nil
+}
+
+func additionalData(seq uint64, rec *record, length int) []byte {
+	ad := make([]byte, 13)
+	binary.BigEndian.PutUint64(ad, seq)
+	ad[8] = rec.typ
+	binary.BigEndian.PutUint16(ad[9:], rec.version)
+	binary.BigEndian.PutUint16(ad[11:], uint16(length))
+	return ad
+}
+
+// prf12 is the TLS 1.2 pseudo random function from RFC 5246 section 5.
+func prf12(h func() hash.Hash, secret []byte, label string, seed []byte, n int) []byte {
+	labelAndSeed := append([]byte(label), seed...)
+	mac := hmac.New(h, secret)
+	mac.Write(labelAndSeed)
+	a := mac.Sum(nil)
+
+	out := make([]byte, 0, n+mac.Size())
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelAndSeed)
+		out = mac.Sum(out)
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return out[:n]
+}
+
+// expandLabel is HKDF-Expand-Label from RFC 8446 section 7.1 with an empty
+// context.
+func expandLabel(h crypto.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	out, err := hkdf.Expand(h.New, secret, string(info), length)
+	if err != nil {
+		// only happens if length is unreasonably large.
+		panic(fmt.Sprintf("tls: hkdf expand failed: %v", err))
+	}
+	return out
+}
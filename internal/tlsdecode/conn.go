@@ -0,0 +1,264 @@
+package tlsdecode
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Labels for the secrets, indexed by whether they're for the client and
+// whether they're the application (rather than handshake) traffic secrets.
+//
+//nolint:gochecknoglobals
+var trafficSecretLabels = map[bool]map[bool]string{
+	true:  {false: labelClientHandshake, true: labelClientApplication0},
+	false: {false: labelServerHandshake, true: labelServerApplication0},
+}
+
+var (
+	errNoSecret           = errors.New("tls: no secret in key log for connection")
+	errUnsupportedSuite   = errors.New("tls: unsupported cipher suite")
+	errUnsupportedVersion = errors.New("tls: unsupported version")
+)
+
+// Conn holds the state shared between the two directions of a TLS
+// connection.  Each direction is decoded by its own Reader, and they need the
+// hellos from both sides to be able to work out the keys.
+type Conn struct {
+	keys *KeyLog
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	clientHello *clientHello
+	serverHello *serverHello
+	alpn        string
+	certificate *x509.Certificate
+	clientDone  bool
+	serverDone  bool
+
+	// when the client started and finished the handshake.
+	started, finished time.Time
+	// first and last times encrypted records were seen from each side.
+	clientSeen, serverSeen []time.Time
+}
+
+// Info is what we can tell about a connection from its handshake, which is
+// mostly sent in the clear so doesn't need the secrets.
+type Info struct {
+	ServerName  string
+	Version     uint16
+	CipherSuite uint16
+	// ALPN is the negotiated protocol.  TLS 1.3 servers send it encrypted
+	// so it's only known if we could decrypt the handshake.
+	ALPN string
+	// Certificate the server presented.  Like the ALPN it's encrypted in
+	// TLS 1.3.
+	Certificate *x509.Certificate
+	// Started is when the ClientHello was seen, and Finished when the
+	// client sent its first encrypted record, which is the end of its side
+	// of the handshake.
+	Started, Finished time.Time
+	// ClientSeen and ServerSeen have the first and last times encrypted
+	// records were seen from each side.
+	ClientSeen, ServerSeen []time.Time
+}
+
+// NewConn returns a Conn that will decrypt using the secrets in keys.
+func NewConn(keys *KeyLog) *Conn {
+	c := &Conn{keys: keys}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Done marks one side of the connection as finished.  Readers reaching the
+// end of their stream wait for the hello from the other side before giving up
+// on decrypting what they have buffered, so this must be called for sides
+// that will never produce one, for example when the other direction wasn't
+// captured.
+func (c *Conn) Done(client bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client {
+		c.clientDone = true
+	} else {
+		c.serverDone = true
+	}
+	c.cond.Broadcast()
+}
+
+// ConnectionState returns the details of the negotiated connection, or nil if
+// we haven't seen the server hello.
+func (c *Conn) ConnectionState() *tls.ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.serverHello == nil {
+		return nil
+	}
+	cs := &tls.ConnectionState{
+		Version:            c.serverHello.version,
+		HandshakeComplete:  true,
+		CipherSuite:        c.serverHello.cipherSuite,
+		NegotiatedProtocol: c.serverHello.alpnProtocol,
+	}
+	if c.alpn != "" {
+		cs.NegotiatedProtocol = c.alpn
+	}
+	if c.clientHello != nil {
+		cs.ServerName = c.clientHello.serverName
+	}
+	return cs
+}
+
+// Info returns what we've seen of the handshake, or nil if the connection
+// didn't get as far as a ClientHello.
+func (c *Conn) Info() *Info {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clientHello == nil {
+		return nil
+	}
+	info := &Info{
+		ServerName:  c.clientHello.serverName,
+		Certificate: c.certificate,
+		Started:     c.started,
+		Finished:    c.finished,
+		ClientSeen:  c.clientSeen,
+		ServerSeen:  c.serverSeen,
+	}
+	if c.serverHello != nil {
+		info.Version = c.serverHello.version
+		info.CipherSuite = c.serverHello.cipherSuite
+		info.ALPN = c.serverHello.alpnProtocol
+	}
+	if c.alpn != "" {
+		info.ALPN = c.alpn
+	}
+	return info
+}
+
+// sawEncryptedRecord notes the time an encrypted record was seen from one
+// side.  The first from the client follows its Finished message, or is its
+// Finished message in TLS 1.3, so marks the end of the handshake.
+func (c *Conn) sawEncryptedRecord(client bool, seen time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	times := &c.serverSeen
+	if client {
+		times = &c.clientSeen
+		if c.finished.IsZero() {
+			c.finished = seen
+		}
+	}
+	switch len(*times) {
+	case 0:
+		*times = []time.Time{seen}
+	case 1:
+		*times = append(*times, seen)
+	default:
+		(*times)[1] = seen
+	}
+}
+
+func (c *Conn) setCertificate(cert *x509.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.certificate == nil {
+		c.certificate = cert
+	}
+}
+
+// version returns the negotiated version, or 0 if we haven't seen the server
+// hello.
+func (c *Conn) version() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.serverHello == nil {
+		return 0
+	}
+	return c.serverHello.version
+}
+
+func (c *Conn) setClientHello(hello *clientHello, seen time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clientHello != nil {
+		// a second client hello follows a hello retry request, the random
+		// is the same so keep the original.
+		return
+	}
+	c.clientHello = hello
+	c.started = seen
+	c.cond.Broadcast()
+}
+
+func (c *Conn) setServerHello(hello *serverHello) {
+	if hello.isHelloRetryRequest() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serverHello = hello
+	c.cond.Broadcast()
+}
+
+func (c *Conn) setALPN(protocol string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alpn = protocol
+}
+
+// waitForPeer blocks until we have the hello from the other side, or know
+// that we're not going to get it.
+func (c *Conn) waitForPeer(client bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if client && (c.serverHello != nil || c.serverDone) {
+			return
+		}
+		if !client && (c.clientHello != nil || c.clientDone) {
+			return
+		}
+		c.cond.Wait()
+	}
+}
+
+// halfConn returns the keys for decrypting one side of the connection, or
+// nil if we don't have both hellos yet.  For TLS 1.3 the application flag
+// selects the application traffic keys rather than the handshake keys.
+func (c *Conn) halfConn(client, application bool) (*halfConn, error) {
+	c.mu.Lock()
+	ch, sh := c.clientHello, c.serverHello
+	c.mu.Unlock()
+	if ch == nil || sh == nil {
+		return nil, nil
+	}
+
+	suite, ok := cipherSuites[sh.cipherSuite]
+	if !ok {
+		return nil, errUnsupportedSuite
+	}
+	switch sh.version {
+	case tls.VersionTLS13:
+		secret := c.keys.secret(trafficSecretLabels[client][application], ch.random)
+		if secret == nil {
+			return nil, errNoSecret
+		}
+		return newHalfConn13(suite, secret)
+	case tls.VersionTLS12:
+		masterSecret := c.keys.secret(labelClientRandom, ch.random)
+		if masterSecret == nil {
+			return nil, errNoSecret
+		}
+		hc, err := newHalfConn12(suite, masterSecret, ch.random, sh.random, client)
+		if err != nil {
+			return nil, err
+		}
+		hc.encryptThenMAC = ch.encryptThenMAC && sh.encryptThenMAC
+		return hc, nil
+	default:
+		return nil, errUnsupportedVersion
+	}
+}
@@ -0,0 +1,222 @@
+package tlsdecode
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+)
+
+// handshake message types we pay attention to.
+const (
+	typeClientHello         uint8 = 1
+	typeServerHello         uint8 = 2
+	typeEncryptedExtensions uint8 = 8
+	typeCertificate         uint8 = 11
+	typeFinished            uint8 = 20
+	typeKeyUpdate           uint8 = 24
+)
+
+// extensions we read from the hellos.
+const (
+	extensionServerName        uint16 = 0
+	extensionALPN              uint16 = 16
+	extensionEncryptThenMAC    uint16 = 22
+	extensionSupportedVersions uint16 = 43
+)
+
+// helloRetryRequest is the special random value a ServerHello carries when
+// it's actually a HelloRetryRequest.
+//
+//nolint:gochecknoglobals
+var helloRetryRequest = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11,
+	0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e,
+	0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+var errShortMessage = errors.New("tls: handshake message truncated")
+
+type clientHello struct {
+	random         []byte
+	serverName     string
+	alpnProtocols  []string
+	encryptThenMAC bool
+}
+
+type serverHello struct {
+	random         []byte
+	version        uint16
+	cipherSuite    uint16
+	alpnProtocol   string
+	encryptThenMAC bool
+}
+
+func (s *serverHello) isHelloRetryRequest() bool {
+	return bytes.Equal(s.random, helloRetryRequest)
+}
+
+// cursor is a minimal reader for the length prefixed structures used in
+// handshake messages.
+type cursor struct {
+	b   []byte
+	err error
+}
+
+func (c *cursor) bytes(n int) []byte {
+	if c.err != nil {
+		return nil
+	}
+	if n > len(c.b) {
+		c.err = errShortMessage
+		return nil
+	}
+	v := c.b[:n]
+	c.b = c.b[n:]
+	return v
+}
+
+func (c *cursor) uint8() uint8 {
+	v := c.bytes(1)
+	if v == nil {
+		return 0
+	}
+	return v[0]
+}
+
+func (c *cursor) uint16() uint16 {
+	v := c.bytes(2)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(v)
+}
+
+func (c *cursor) uint24() int {
+	v := c.bytes(3)
+	if v == nil {
+		return 0
+	}
+	return int(v[0])<<16 | int(v[1])<<8 | int(v[2])
+}
+
+func (c *cursor) vector8() *cursor {
+	return &cursor{b: c.bytes(int(c.uint8())), err: c.err}
+}
+
+func (c *cursor) vector16() *cursor {
+	return &cursor{b: c.bytes(int(c.uint16())), err: c.err}
+}
+
+func (c *cursor) empty() bool {
+	return c.err != nil || len(c.b) == 0
+}
+
+// extensions calls fn for each extension in the block.
+func (c *cursor) extensions(fn func(typ uint16, data *cursor)) {
+	if c.empty() {
+		// extensions are optional.
+		return
+	}
+	exts := c.vector16()
+	for !exts.empty() {
+		typ := exts.uint16()
+		data := exts.vector16()
+		if exts.err != nil {
+			c.err = exts.err
+			return
+		}
+		fn(typ, data)
+	}
+}
+
+func parseClientHello(body []byte) (*clientHello, error) {
+	c := &cursor{b: body}
+	c.uint16() // legacy version
+	hello := &clientHello{random: c.bytes(32)}
+	c.vector8()  // session id
+	c.vector16() // cipher suites
+	c.vector8()  // compression methods
+	c.extensions(func(typ uint16, data *cursor) {
+		switch typ {
+		case extensionServerName:
+			names := data.vector16()
+			for !names.empty() {
+				nameType := names.uint8()
+				name := names.vector16()
+				if nameType == 0 {
+					hello.serverName = string(name.b)
+				}
+			}
+		case extensionALPN:
+			protocols := data.vector16()
+			for !protocols.empty() {
+				hello.alpnProtocols = append(hello.alpnProtocols, string(protocols.vector8().b))
+			}
+		case extensionEncryptThenMAC:
+			hello.encryptThenMAC = true
+		}
+	})
+	if c.err != nil {
+		return nil, c.err
+	}
+	return hello, nil
+}
+
+func parseServerHello(body []byte) (*serverHello, error) {
+	c := &cursor{b: body}
+	hello := &serverHello{version: c.uint16(), random: c.bytes(32)}
+	c.vector8() // session id
+	hello.cipherSuite = c.uint16()
+	c.uint8() // compression method
+	c.extensions(func(typ uint16, data *cursor) {
+		switch typ {
+		case extensionSupportedVersions:
+			hello.version = data.uint16()
+		case extensionALPN:
+			hello.alpnProtocol = parseALPN(data)
+		case extensionEncryptThenMAC:
+			hello.encryptThenMAC = true
+		}
+	})
+	if c.err != nil {
+		return nil, c.err
+	}
+	return hello, nil
+}
+
+// parseEncryptedExtensions returns the ALPN protocol selected by a TLS 1.3
+// server.
+func parseEncryptedExtensions(body []byte) string {
+	var protocol string
+	c := &cursor{b: body}
+	c.extensions(func(typ uint16, data *cursor) {
+		if typ == extensionALPN {
+			protocol = parseALPN(data)
+		}
+	})
+	return protocol
+}
+
+func parseALPN(data *cursor) string {
+	protocols := data.vector16()
+	return string(protocols.vector8().b)
+}
+
+// parseCertificate returns the server's own certificate, the first in the
+// chain.  TLS 1.3 adds a request context and extensions for each
+// certificate.
+func parseCertificate(body []byte, version uint16) (*x509.Certificate, error) {
+	c := &cursor{b: body}
+	if version == tls.VersionTLS13 {
+		c.vector8() // certificate request context
+	}
+	list := &cursor{b: c.bytes(c.uint24()), err: c.err}
+	der := list.bytes(list.uint24())
+	if list.err != nil {
+		return nil, list.err
+	}
+	return x509.ParseCertificate(der)
+}
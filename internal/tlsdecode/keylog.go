@@ -0,0 +1,126 @@
+// Package tlsdecode reads TLS records from captured tcp streams and, given the
+// secrets from an NSS key log, decrypts the application data they carry.
+//
+// See https://developer.mozilla.org/en-US/docs/Mozilla/Projects/NSS/Key_Log_Format
+// for the key log format.
+package tlsdecode
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Labels used in the key log that we know how to make use of.
+const (
+	labelClientRandom       = "CLIENT_RANDOM"
+	labelClientHandshake    = "CLIENT_HANDSHAKE_TRAFFIC_SECRET"
+	labelServerHandshake    = "SERVER_HANDSHAKE_TRAFFIC_SECRET"
+	labelClientApplication0 = "CLIENT_TRAFFIC_SECRET_0"
+	labelServerApplication0 = "SERVER_TRAFFIC_SECRET_0"
+)
+
+// KeyLog holds the secrets read from a key log, indexed by the client random
+// of the connection they belong to.
+type KeyLog struct {
+	mu      sync.Mutex
+	secrets map[string]map[string][]byte
+	// skipped is the number of lines that couldn't be parsed.
+	skipped int
+}
+
+// NewKeyLog returns an empty KeyLog.
+func NewKeyLog() *KeyLog {
+	return &KeyLog{secrets: make(map[string]map[string][]byte)}
+}
+
+// LoadKeyLog reads the key log file at filename.
+func LoadKeyLog(filename string) (*KeyLog, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k := NewKeyLog()
+	if err := k.Parse(f); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return k, nil
+}
+
+// Parse reads key log lines from r and adds them to the key log.  Comments and
+// blank lines are skipped, as are lines that aren't in the format we expect,
+// as the programs writing key logs sometimes add other things to them.
+// Skipped says how many of those there were.
+func (k *KeyLog) Parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if !k.parseLine(text) {
+			k.mu.Lock()
+			k.skipped++
+			k.mu.Unlock()
+		}
+	}
+	return scanner.Err()
+}
+
+// parseLine adds the secret from a line of the key log, returning false if
+// it isn't a label followed by the client random and secret in hex.
+func (k *KeyLog) parseLine(text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) != 3 {
+		return false
+	}
+	clientRandom, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return false
+	}
+	secret, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return false
+	}
+	k.add(fields[0], clientRandom, secret)
+	return true
+}
+
+// Len returns the number of connections we have secrets for.
+func (k *KeyLog) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.secrets)
+}
+
+// Skipped returns the number of lines that couldn't be parsed.
+func (k *KeyLog) Skipped() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.skipped
+}
+
+func (k *KeyLog) add(label string, clientRandom, secret []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key := string(clientRandom)
+	if k.secrets[key] == nil {
+		k.secrets[key] = make(map[string][]byte)
+	}
+	k.secrets[key][label] = secret
+}
+
+func (k *KeyLog) secret(label string, clientRandom []byte) []byte {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.secrets[string(clientRandom)][label]
+}
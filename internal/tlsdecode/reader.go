@@ -0,0 +1,285 @@
+package tlsdecode
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// record content types.
+const (
+	recordTypeChangeCipherSpec uint8 = 20
+	recordTypeHandshake        uint8 = 22
+	recordTypeApplicationData  uint8 = 23
+)
+
+const (
+	recordHeaderLen = 5
+	// maximum size of an encrypted record payload.
+	maxCiphertext = 16384 + 2048
+)
+
+// Stream is the tcp stream the records are read from.
+type Stream interface {
+	Read(p []byte) (n int, err error)
+	Seen() (time.Time, error)
+}
+
+type record struct {
+	typ     uint8
+	version uint16
+	payload []byte
+	seen    time.Time
+}
+
+func (rec *record) header() []byte {
+	h := make([]byte, recordHeaderLen)
+	h[0] = rec.typ
+	binary.BigEndian.PutUint16(h[1:], rec.version)
+	binary.BigEndian.PutUint16(h[3:], uint16(len(rec.payload)))
+	return h
+}
+
+// Sniff reports whether the start of a stream looks like a TLS handshake.
+// It needs the record header and the first byte of the handshake message, and
+// also reports whether that's a ClientHello, i.e. we're looking at the client
+// side of the connection.
+func Sniff(start []byte) (ok, client bool) {
+	if len(start) < recordHeaderLen+1 ||
+		start[0] != recordTypeHandshake ||
+		start[1] != 3 || start[2] > 4 {
+		return false, false
+	}
+	switch start[recordHeaderLen] {
+	case typeClientHello:
+		return true, true
+	case typeServerHello:
+		return true, false
+	}
+	return false, false
+}
+
+// Reader decrypts one direction of a TLS connection, implementing the same
+// Read and Seen methods as the tcp Stream it reads from so that it can be
+// used in its place.
+//
+// Like the tcp stream, it must be read until it returns io.EOF.
+type Reader struct {
+	conn   *Conn
+	r      Stream
+	client bool
+
+	plaintext []byte
+	seen      time.Time
+	lastSeen  time.Time
+
+	handshake []byte
+	// set once this side has sent a change cipher spec.
+	changedCipherSpec bool
+	hc                *halfConn
+	// TLS 1.3 connections start with handshake keys and move to application
+	// keys after the Finished message.
+	application bool
+	// encrypted records we don't have the keys for yet.
+	pending []*record
+	// failed is set once we know we can't decrypt the stream.
+	failed bool
+	eof    bool
+}
+
+// NewReader returns a Reader decoding the client or server side of the
+// connection c from r.
+func NewReader(c *Conn, r Stream, client bool) *Reader {
+	return &Reader{conn: c, r: r, client: client}
+}
+
+// Read implements io.Reader returning the decrypted application data.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		rec, err := r.readRecord()
+		if err != nil {
+			r.finish()
+			continue
+		}
+		r.handleRecord(rec)
+	}
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+// Seen returns the time the record containing the last data returned by Read
+// was seen on the wire.
+func (r *Reader) Seen() (time.Time, error) {
+	if r.seen.IsZero() {
+		return time.Time{}, io.EOF
+	}
+	return r.seen, nil
+}
+
+func (r *Reader) readRecord() (*record, error) {
+	var h [recordHeaderLen]byte
+	if _, err := io.ReadFull(r.r, h[:]); err != nil {
+		return nil, err
+	}
+	if seen, err := r.r.Seen(); err == nil {
+		r.lastSeen = seen
+	}
+	rec := &record{
+		typ:     h[0],
+		version: binary.BigEndian.Uint16(h[1:]),
+		seen:    r.lastSeen,
+	}
+	length := int(binary.BigEndian.Uint16(h[3:]))
+	if rec.typ < recordTypeChangeCipherSpec || rec.typ > recordTypeApplicationData ||
+		rec.version>>8 != 3 || length > maxCiphertext {
+		// we've lost track of the record boundaries, there's no getting
+		// back from that.
+		_, err := io.Copy(io.Discard, r.r)
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	rec.payload = make([]byte, length)
+	if _, err := io.ReadFull(r.r, rec.payload); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// finish is called at the end of the stream.  It waits to see if the other
+// side can give us what we need to decrypt anything still pending.
+func (r *Reader) finish() {
+	r.eof = true
+	r.conn.Done(r.client)
+	if len(r.pending) == 0 || r.failed {
+		return
+	}
+	r.conn.waitForPeer(r.client)
+	r.decryptPending()
+	r.pending = nil
+}
+
+func (r *Reader) handleRecord(rec *record) {
+	if rec.typ == recordTypeChangeCipherSpec {
+		r.changedCipherSpec = true
+		return
+	}
+	if rec.typ == recordTypeApplicationData || r.changedCipherSpec {
+		r.conn.sawEncryptedRecord(r.client, rec.seen)
+		if r.failed {
+			return
+		}
+		r.pending = append(r.pending, rec)
+		r.decryptPending()
+		return
+	}
+	if rec.typ == recordTypeHandshake {
+		r.handleHandshake(rec.payload)
+	}
+}
+
+func (r *Reader) decryptPending() {
+	if r.hc == nil {
+		hc, err := r.conn.halfConn(r.client, false)
+		if err != nil {
+			r.giveUp()
+			return
+		}
+		if hc == nil {
+			// still waiting on the other side.
+			return
+		}
+		r.hc = hc
+	}
+	for len(r.pending) > 0 && !r.failed {
+		rec := r.pending[0]
+		r.pending = r.pending[1:]
+		r.decryptRecord(rec)
+	}
+}
+
+func (r *Reader) decryptRecord(rec *record) {
+	typ, data, err := r.hc.decrypt(rec)
+	if err != nil && r.hc.version == tls.VersionTLS13 && !r.application {
+		// the key log may only have the application secrets, in which
+		// case we won't see the handshake, but can still get the data.
+		if hc, err2 := r.conn.halfConn(r.client, true); err2 == nil {
+			r.hc, r.application = hc, true
+			typ, data, err = r.hc.decrypt(rec)
+		}
+	}
+	if err != nil {
+		r.giveUp()
+		return
+	}
+	switch typ {
+	case recordTypeApplicationData:
+		r.plaintext = append(r.plaintext, data...)
+		r.seen = rec.seen
+	case recordTypeHandshake:
+		r.handleHandshake(data)
+	}
+}
+
+func (r *Reader) giveUp() {
+	r.failed = true
+	r.pending = nil
+}
+
+// handleHandshake processes the handshake messages in a record.  Messages can
+// be split across records so we hold on to anything incomplete.
+func (r *Reader) handleHandshake(data []byte) {
+	r.handshake = append(r.handshake, data...)
+	for len(r.handshake) >= 4 {
+		c := cursor{b: r.handshake}
+		typ := c.uint8()
+		length := c.uint24()
+		if len(c.b) < length {
+			return
+		}
+		r.handleHandshakeMessage(typ, c.b[:length])
+		r.handshake = c.b[length:]
+	}
+}
+
+func (r *Reader) handleHandshakeMessage(typ uint8, body []byte) {
+	switch typ {
+	case typeClientHello:
+		if hello, err := parseClientHello(body); err == nil {
+			r.conn.setClientHello(hello, r.lastSeen)
+		}
+	case typeServerHello:
+		if hello, err := parseServerHello(body); err == nil {
+			r.conn.setServerHello(hello)
+		}
+	case typeEncryptedExtensions:
+		r.conn.setALPN(parseEncryptedExtensions(body))
+	case typeCertificate:
+		if !r.client {
+			if cert, err := parseCertificate(body, r.conn.version()); err == nil {
+				r.conn.setCertificate(cert)
+			}
+		}
+	case typeFinished:
+		if r.hc != nil && r.hc.version == tls.VersionTLS13 && !r.application {
+			hc, err := r.conn.halfConn(r.client, true)
+			if err != nil {
+				r.giveUp()
+				return
+			}
+			r.hc, r.application = hc, true
+		}
+	case typeKeyUpdate:
+		if r.hc != nil && r.application {
+			if err := r.hc.updateKeys(); err != nil {
+				r.giveUp()
+			}
+		}
+	}
+}
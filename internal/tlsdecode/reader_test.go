@@ -0,0 +1,286 @@
+package tlsdecode_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/colinnewell/pcap2har-go/internal/tlsdecode"
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	request  = "GET / HTTP/1.1\r\nHost: example.test\r\n\r\n"
+	response = "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}"
+)
+
+// recordingConn keeps a copy of everything written to the connection, giving
+// us what would have been captured on the wire for that direction.
+type recordingConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (r *recordingConn) Write(b []byte) (int, error) {
+	r.buf.Write(b)
+	return r.Conn.Write(b)
+}
+
+// stream is a fake tcp stream over captured bytes.
+type stream struct {
+	*bytes.Reader
+}
+
+func (s stream) Seen() (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func certificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.test"},
+		DNSNames:     []string{"example.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// capture runs an HTTP style exchange over TLS returning the bytes sent by
+// the client and server, and the key log.
+func capture(t *testing.T, version uint16, suite uint16) (client, server, keyLog []byte) {
+	t.Helper()
+	c, s := net.Pipe()
+	clientConn := &recordingConn{Conn: c}
+	serverConn := &recordingConn{Conn: s}
+	var keys bytes.Buffer
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate(t)},
+		MaxVersion:   version,
+		NextProtos:   []string{"http/1.1"},
+	}
+	clientConfig := &tls.Config{
+		//nolint:gosec
+		InsecureSkipVerify: true,
+		ServerName:         "example.test",
+		MaxVersion:         version,
+		NextProtos:         []string{"http/1.1"},
+		KeyLogWriter:       &keys,
+	}
+	if suite != 0 {
+		clientConfig.CipherSuites = []uint16{suite}
+		serverConfig.CipherSuites = []uint16{suite}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer serverConn.Close()
+		conn := tls.Server(serverConn, serverConfig)
+		buf := make([]byte, len(request))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := conn.Write([]byte(response)); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conn := tls.Client(clientConn, clientConfig)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(response))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	clientConn.Close()
+	wg.Wait()
+
+	return clientConn.buf.Bytes(), serverConn.buf.Bytes(), keys.Bytes()
+}
+
+// decode reads both sides of the connection at the same time, the way the
+// tcp assembly would give them to us.
+func decode(conn *tlsdecode.Conn, client, server []byte) (clientData, serverData []byte) {
+	var wg sync.WaitGroup
+	read := func(data []byte, isClient bool, out *[]byte) {
+		defer wg.Done()
+		r := tlsdecode.NewReader(conn, stream{bytes.NewReader(data)}, isClient)
+		*out, _ = io.ReadAll(r)
+	}
+	wg.Add(2)
+	go read(client, true, &clientData)
+	go read(server, false, &serverData)
+	wg.Wait()
+	return clientData, serverData
+}
+
+func TestDecrypt(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint16
+		suite   uint16
+	}{
+		{"TLS 1.3", tls.VersionTLS13, 0},
+		{"AES-128-GCM", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		{"AES-256-GCM", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		{"ChaCha20", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		{"AES-128-CBC-SHA", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA},
+		{"AES-256-CBC-SHA", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server, keyLog := capture(t, test.version, test.suite)
+			keys := tlsdecode.NewKeyLog()
+			if err := keys.Parse(bytes.NewReader(keyLog)); err != nil {
+				t.Fatal(err)
+			}
+
+			conn := tlsdecode.NewConn(keys)
+			clientData, serverData := decode(conn, client, server)
+			if diff := cmp.Diff(string(clientData), request); diff != "" {
+				t.Errorf("Client data doesn't match (-got +expected):\n%s\n", diff)
+			}
+			if diff := cmp.Diff(string(serverData), response); diff != "" {
+				t.Errorf("Server data doesn't match (-got +expected):\n%s\n", diff)
+			}
+
+			cs := conn.ConnectionState()
+			if cs == nil {
+				t.Fatal("No connection state")
+			}
+			if cs.Version != test.version {
+				t.Errorf("Expected version %x, got %x", test.version, cs.Version)
+			}
+			if test.suite != 0 && cs.CipherSuite != test.suite {
+				t.Errorf("Expected cipher suite %x, got %x", test.suite, cs.CipherSuite)
+			}
+			if cs.ServerName != "example.test" {
+				t.Errorf("Unexpected server name %s", cs.ServerName)
+			}
+			if cs.NegotiatedProtocol != "http/1.1" {
+				t.Errorf("Unexpected ALPN protocol %s", cs.NegotiatedProtocol)
+			}
+		})
+	}
+}
+
+func TestDecryptWithoutSecrets(t *testing.T) {
+	client, server, _ := capture(t, tls.VersionTLS13, 0)
+	conn := tlsdecode.NewConn(tlsdecode.NewKeyLog())
+	clientData, serverData := decode(conn, client, server)
+	if len(clientData) != 0 || len(serverData) != 0 {
+		t.Errorf("Didn't expect to decrypt anything, got %q and %q", clientData, serverData)
+	}
+}
+
+func TestDecryptOneSide(t *testing.T) {
+	client, _, keyLog := capture(t, tls.VersionTLS12, 0)
+	keys := tlsdecode.NewKeyLog()
+	if err := keys.Parse(bytes.NewReader(keyLog)); err != nil {
+		t.Fatal(err)
+	}
+	conn := tlsdecode.NewConn(keys)
+	// without the server side we never get the server hello.
+	conn.Done(false)
+	r := tlsdecode.NewReader(conn, stream{bytes.NewReader(client)}, true)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Didn't expect to decrypt anything, got %q", data)
+	}
+}
+
+func TestKeyLogParse(t *testing.T) {
+	keys := tlsdecode.NewKeyLog()
+	err := keys.Parse(bytes.NewBufferString("# comment\n\nCLIENT_RANDOM 0102 0304\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Len() != 1 {
+		t.Errorf("Expected 1 connection, got %d", keys.Len())
+	}
+	// odd lines are skipped rather than losing the rest of the secrets.
+	err = keys.Parse(bytes.NewBufferString(
+		"CLIENT_RANDOM zz 0304\nsomething else\nCLIENT_RANDOM 0506 0708\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Len() != 2 {
+		t.Errorf("Expected 2 connections, got %d", keys.Len())
+	}
+	if keys.Skipped() != 2 {
+		t.Errorf("Expected 2 lines to be skipped, got %d", keys.Skipped())
+	}
+}
+
+func TestInfoWithoutSecrets(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     uint16
+		certificate bool
+	}{
+		{"TLS 1.2", tls.VersionTLS12, true},
+		// the certificate is encrypted in TLS 1.3.
+		{"TLS 1.3", tls.VersionTLS13, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server, _ := capture(t, test.version, 0)
+			conn := tlsdecode.NewConn(nil)
+			decode(conn, client, server)
+
+			info := conn.Info()
+			if info == nil {
+				t.Fatal("No info")
+			}
+			if info.ServerName != "example.test" {
+				t.Errorf("Unexpected server name %s", info.ServerName)
+			}
+			if info.Version != test.version {
+				t.Errorf("Expected version %x, got %x", test.version, info.Version)
+			}
+			if info.CipherSuite == 0 {
+				t.Error("Expected cipher suite")
+			}
+			if test.certificate {
+				if info.Certificate == nil || info.Certificate.Subject.CommonName != "example.test" {
+					t.Errorf("Unexpected certificate %#v", info.Certificate)
+				}
+				if info.ALPN != "http/1.1" {
+					t.Errorf("Unexpected ALPN protocol %s", info.ALPN)
+				}
+			} else if info.Certificate != nil {
+				t.Error("Didn't expect to see the certificate")
+			}
+			if len(info.ClientSeen) != 2 || len(info.ServerSeen) != 2 {
+				t.Errorf("Expected first and last times, got %v and %v", info.ClientSeen, info.ServerSeen)
+			}
+		})
+	}
+}